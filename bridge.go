@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/stronnag/msp-go/msp"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// bclient is a TCP client; replies are queued on out for its writer, so a
+// slow client delays only itself.
+type bclient struct {
+	conn net.Conn
+	dead bool
+	out  chan []byte
+	done chan struct{}
+}
+
+func new_bclient(conn net.Conn) *bclient {
+	return &bclient{conn: conn, out: make(chan []byte, 64), done: make(chan struct{})}
+}
+
+// writer sends the queued replies; a client that does not take one within
+// timeout is disconnected.
+func (c *bclient) writer(timeout time.Duration) {
+	for {
+		select {
+		case rb := <-c.out:
+			c.conn.SetWriteDeadline(time.Now().Add(timeout))
+			if _, err := c.conn.Write(rb); err != nil {
+				c.conn.Close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+type bwait struct {
+	c    *bclient
+	req  []byte
+	sent time.Time
+}
+
+// bridge shares one FC between several TCP clients. Only one request per
+// command is outstanding at the FC, others wait their turn, so each reply
+// belongs to the client at the head of that command's queue. A request
+// unanswered after timeout is forgotten and the next one sent, so a lost
+// reply neither stalls the queue nor shifts replies to the wrong client.
+type bridge struct {
+	sp      *msp.Client
+	timeout time.Duration
+	mu      sync.Mutex
+	pending map[uint16][]bwait
+}
+
+func (b *bridge) forward(c *bclient, f msp.Frame) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if f.Flags&msp.Flag_DONT_REPLY != 0 {
+		b.sp.Write(f.Encode())
+		return
+	}
+	b.pending[f.Cmd] = append(b.pending[f.Cmd], bwait{c: c, req: f.Encode()})
+	if len(b.pending[f.Cmd]) == 1 {
+		b.next(f.Cmd)
+	}
+}
+
+// next sends the request at the head of cmd's queue; b.mu must be held.
+func (b *bridge) next(cmd uint16) {
+	if q := b.pending[cmd]; len(q) > 0 {
+		q[0].sent = time.Now()
+		b.sp.Write(q[0].req)
+	} else {
+		delete(b.pending, cmd)
+	}
+}
+
+// expire drops cmd's outstanding request if older than b.timeout; b.mu must
+// be held.
+func (b *bridge) expire(cmd uint16, now time.Time) {
+	q := b.pending[cmd]
+	if len(q) > 0 && now.Sub(q[0].sent) > b.timeout {
+		log.Printf("bridge: %s from %s unanswered", msp.CmdName(cmd), q[0].c.conn.RemoteAddr())
+		b.pending[cmd] = q[1:]
+		b.next(cmd)
+	}
+}
+
+func (b *bridge) expire_all() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	for cmd := range b.pending {
+		b.expire(cmd, now)
+	}
+}
+
+func (b *bridge) route(f msp.Frame) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q := b.pending[f.Cmd]
+	if len(q) == 0 {
+		return
+	}
+	c := q[0].c
+	b.pending[f.Cmd] = q[1:]
+	b.next(f.Cmd)
+	// A corrupt reply is dropped, the client will time out and resend
+	if !c.dead && f.Err != msp.ErrCRC {
+		// route runs on the FC reader, so it must never block
+		select {
+		case c.out <- f.Encode():
+		default:
+			log.Printf("bridge: %s: %s reply dropped, client not reading", c.conn.RemoteAddr(), msp.CmdName(f.Cmd))
+		}
+	}
+}
+
+func (b *bridge) serve(c *bclient) {
+	var parser msp.Parser
+	buf := make([]byte, 256)
+	for {
+		n, err := c.conn.Read(buf)
+		if err != nil {
+			break
+		}
+		for _, ch := range buf[:n] {
+			for _, f := range parser.Parse(ch) {
+				if f.Dirn == '<' && f.Err == nil {
+					b.forward(c, f)
+				}
+			}
+		}
+	}
+	b.mu.Lock()
+	c.dead = true
+	b.mu.Unlock()
+	close(c.done)
+	c.conn.Close()
+	log.Printf("bridge: %s disconnected", c.conn.RemoteAddr())
+}
+
+func run_bridge(args []string) {
+	fs := flag.NewFlagSet("bridge", flag.ExitOnError)
+	listen := fs.String("listen", ":5761", "TCP address to serve MSP on")
+	timeout := fs.Duration("timeout", time.Second, "Forget requests unanswered after this time")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of mspview bridge [options] device\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	devnam := fs.Arg(0)
+	if devnam == "" {
+		devnam, _ = enumerate_ports()
+	}
+
+	sp, err := msp.NewClient(context.Background(), devnam, true)
+	if err != nil {
+		log.Fatalf("bridge: %s: %v", devnam, err)
+	}
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatalf("bridge: %v", err)
+	}
+	log.Printf("bridge: %s on %s", devnam, l.Addr())
+
+	b := &bridge{sp: sp, timeout: *timeout, pending: make(map[uint16][]bwait)}
+	// replies are routed from the reader, so none is lost to a busy consumer
+	sp.Tap(msp.AnyCmd, b.route)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				log.Fatalf("bridge: %v", err)
+			}
+			log.Printf("bridge: %s connected", conn.RemoteAddr())
+			c := new_bclient(conn)
+			go c.writer(*timeout)
+			go b.serve(c)
+		}
+	}()
+
+	ticker := time.NewTicker(*timeout / 4)
+	for {
+		select {
+		case <-ticker.C:
+			b.expire_all()
+		case <-sp.Done():
+			log.Fatalf("bridge: %s: %v", devnam, sp.Err())
+		}
+	}
+}
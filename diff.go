@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"github.com/stronnag/msp-go/msp"
+	"log"
+	"os"
+	"text/tabwriter"
+)
+
+// diff_key is the key of a setting: its name, or with all profiles its
+// setting_key.
+func diff_key(m *msp.SettingInfo, all bool) string {
+	if all {
+		return info_key(m)
+	}
+	return m.Name
+}
+
+// diff_source is the other side of a diff, a saved dump or a second FC, keyed
+// as diff_key. The kind of each setting in a file and the profile of a kind
+// the file does not select are those of the first FC, kinds and active; unless
+// all, settings of the file's other profiles are ignored.
+func diff_source(ctx context.Context, arg string, all bool, kinds map[string]int, active map[int]int) (map[string]string, []string, error) {
+	if st, err := os.Stat(arg); err == nil && st.Mode().IsRegular() {
+		sl, err := load_settings(arg)
+		if err != nil {
+			return nil, nil, err
+		}
+		m := make(map[string]string)
+		var names []string
+		for _, s := range sl {
+			k := kinds[s.name]
+			n := s.prof[k]
+			if n == 0 {
+				n = active[k]
+			}
+			key := s.name
+			if all {
+				key = setting_key(s.name, k, n)
+			} else if n != active[k] {
+				continue
+			}
+			m[key] = s.value
+			names = append(names, key)
+		}
+		return m, names, nil
+	}
+	sp, err := open_fc(ctx, arg)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer sp.Close()
+	sl, err := fetch_settings(ctx, sp, all)
+	if err != nil {
+		return nil, nil, err
+	}
+	m := make(map[string]string)
+	var names []string
+	for _, s := range sl {
+		key := diff_key(&s, all)
+		m[key] = s.ValueString()
+		names = append(names, key)
+	}
+	return m, names, nil
+}
+
+func run_diff(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	all := fs.Bool("profiles", false, "Compare every profile, selecting each in turn (which makes the FC save its settings to EEPROM)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of mspview diff [options] device file|device\n")
+		fmt.Fprintf(os.Stderr, "Prints the settings that differ, with their ranges (defaults are not available over MSP)\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	adev, bsrc := fs.Arg(0), fs.Arg(1)
+
+	ctx := context.Background()
+	sp, err := open_fc(ctx, adev)
+	if err != nil {
+		log.Fatalf("diff: %v", err)
+	}
+	defer sp.Close()
+	al, err := fetch_settings(ctx, sp, *all)
+	if err != nil {
+		log.Fatalf("diff: %v", err)
+	}
+	// the active profile (from 1) of each kind; with all profiles, al has
+	// each, so ask the FC
+	kinds := make(map[string]int)
+	active := make(map[int]int)
+	for _, a := range al {
+		k := a.ProfileKind()
+		kinds[a.Name] = k
+		if _, ok := active[k]; !ok && k != msp.Profile_NONE {
+			info := a
+			if *all {
+				if info, err = sp.SettingInfo(ctx, a.Name); err != nil {
+					log.Fatalf("diff: %v", err)
+				}
+			}
+			active[k] = int(info.Profile) + 1
+		}
+	}
+	bm, bnames, err := diff_source(ctx, bsrc, *all, kinds, active)
+	if err != nil {
+		log.Fatalf("diff: %s: %v", bsrc, err)
+	}
+
+	// Values are compared in encoded form, so "8" and "8.0" or "msp" and
+	// "MSP" are the same; the FC does not report defaults over MSP.
+	ndiff := 0
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "setting\t%s\t%s\trange\n", adev, bsrc)
+	seen := make(map[string]bool)
+	for _, a := range al {
+		key := diff_key(&a, *all)
+		seen[key] = true
+		bv, ok := bm[key]
+		if !ok {
+			fmt.Fprintf(tw, "%s\t%s\t(absent)\t%s\n", key, a.ValueString(), a.Range())
+			ndiff++
+			continue
+		}
+		if v, err := a.Parse(bv); err == nil && bytes.Equal(v, a.Value) {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", key, a.ValueString(), bv, a.Range())
+		ndiff++
+	}
+	for _, n := range bnames {
+		if !seen[n] {
+			fmt.Fprintf(tw, "%s\t(absent)\t%s\t\n", n, bm[n])
+			ndiff++
+		}
+	}
+	tw.Flush()
+	log.Printf("diff: %d of %d settings differ", ndiff, len(al))
+	if ndiff > 0 {
+		os.Exit(1)
+	}
+}
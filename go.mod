@@ -1,4 +1,4 @@
-module mspview
+module github.com/stronnag/msp-go
 
 go 1.18
 
@@ -7,6 +7,7 @@ require (
 	github.com/albenik/go-serial/v2 v2.6.1
 	github.com/gdamore/tcell/v2 v2.7.0
 	github.com/go-ini/ini v1.67.0
+	golang.org/x/sys v0.15.0
 )
 
 require (
@@ -17,7 +18,6 @@ require (
 	github.com/rivo/uniseg v0.4.3 // indirect
 	go.uber.org/atomic v1.10.0 // indirect
 	go.uber.org/multierr v1.9.0 // indirect
-	golang.org/x/sys v0.15.0 // indirect
 	golang.org/x/term v0.15.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
 )
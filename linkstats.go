@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stronnag/msp-go/msp"
+	"strings"
+	"time"
+)
+
+func ms(d time.Duration) string {
+	return fmt.Sprintf("%.1f", float64(d)/float64(time.Millisecond))
+}
+
+func crc_rate(st msp.Stats) float64 {
+	if n := st.Frames + st.CRCErrors; n > 0 {
+		return 100.0 * float64(st.CRCErrors) / float64(n)
+	}
+	return 0
+}
+
+// latency_line is the one line summary; bps is the current throughput.
+func latency_line(sp *msp.Client, st msp.Stats, bps float64) string {
+	l := sp.Latency(msp.AnyCmd)
+	return fmt.Sprintf("rtt %s/%s/%s/%sms, %d timeouts, %d retries, CRC %.2f%%, %.0f B/s",
+		ms(l.Min), ms(l.Avg), ms(l.P95), ms(l.Max), l.Timeouts, l.Retries, crc_rate(st), bps)
+}
+
+// link_summary is the per command report printed on exit.
+func link_summary(sp *msp.Client, dura time.Duration) string {
+	var sb strings.Builder
+	st := sp.Stats()
+	secs := dura.Seconds()
+	fmt.Fprintf(&sb, "Link: %d bytes rx (%.0f B/s), %d bytes tx (%.0f B/s), %d CRC errors (%.2f%%)\n",
+		st.RxBytes, float64(st.RxBytes)/secs, st.TxBytes, float64(st.TxBytes)/secs, st.CRCErrors, crc_rate(st))
+	fmt.Fprintf(&sb, "%-20s %8s %8s %8s %8s %8s %8s %8s\n", "Command", "Replies", "Timeouts", "Retries", "Min ms", "Avg ms", "P95 ms", "Max ms")
+	for _, l := range append(sp.Latencies(), sp.Latency(msp.AnyCmd)) {
+		name := msp.CmdName(l.Cmd)
+		if l.Cmd == msp.AnyCmd {
+			name = "All"
+		}
+		fmt.Fprintf(&sb, "%-20s %8d %8d %8d %8s %8s %8s %8s\n", name, l.Replies, l.Timeouts, l.Retries, ms(l.Min), ms(l.Avg), ms(l.P95), ms(l.Max))
+	}
+	return sb.String()
+}
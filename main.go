@@ -1,10 +1,11 @@
 package main
 
 import (
-	"encoding/binary"
+	"context"
 	"flag"
 	"fmt"
 	"github.com/albenik/go-serial/enumerator"
+	"github.com/stronnag/msp-go/msp"
 	"os"
 	"runtime"
 	"strings"
@@ -13,13 +14,6 @@ import (
 	"github.com/gdamore/tcell/v2"
 )
 
-type SChan struct {
-	len  uint16
-	cmd  uint16
-	ok   uint8
-	data []byte
-}
-
 const VERSION = "v0.12.0"
 
 const (
@@ -35,8 +29,13 @@ const (
 	IY_UPTIME
 	IY_ANALOG
 	IY_GPS
+	IY_ATT
 	IY_ARM
 	IY_RATE
+	IY_LINK
+	IY_LATENCY
+	IY_STALL
+	IY_REJECT
 	IY_DEBUG
 )
 
@@ -56,8 +55,13 @@ var uiset = []struct {
 	{IY_UPTIME, "Uptime"},
 	{IY_ANALOG, "Power"},
 	{IY_GPS, "GPS"},
+	{IY_ATT, "Attitude"},
 	{IY_ARM, "Arming"},
 	{IY_RATE, "Rate"},
+	{IY_LINK, "Link"},
+	{IY_LATENCY, "Latency"},
+	{IY_STALL, "Stalled"},
+	{IY_REJECT, "Rejects"},
 	{IY_DEBUG, "Debug"},
 }
 
@@ -65,6 +69,7 @@ var (
 	width    int
 	height   int
 	defstyle tcell.Style
+	nreject  int
 )
 
 func drawText(s tcell.Screen, x, y int, style tcell.Style, text string) {
@@ -100,6 +105,21 @@ func set_no_value(s tcell.Screen, id int) {
 	set_value(s, id, "---", tcell.StyleDefault.Dim(true))
 }
 
+// decode_value unmarshals a good frame into m; a payload that does not match
+// the message schema is flagged on line id and counted as rejected.
+func decode_value(s tcell.Screen, id int, v msp.Frame, m msp.Unmarshaler) bool {
+	if v.Err != nil {
+		return false
+	}
+	if err := m.Unmarshal(v.Data); err != nil {
+		nreject++
+		set_value(s, id, "invalid payload", tcell.StyleDefault.Foreground(tcell.ColorRed))
+		set_value(s, IY_REJECT, fmt.Sprintf("%d frames (%s: %v)", nreject, msp.CmdName(v.Cmd), err), defstyle)
+		return false
+	}
+	return true
+}
+
 func clear_err(s tcell.Screen) {
 	for j := 0; j < width; j++ {
 		s.SetContent(j, height-2, rune(' '), nil, defstyle)
@@ -159,19 +179,71 @@ func enumerate_ports() (string, error) {
 	return "", err
 }
 
+// open_fc connects to devnam (or the first FC found if empty) for the
+// command line tools, negotiating the protocol version.
+func open_fc(ctx context.Context, devnam string) (*msp.Client, error) {
+	if devnam == "" {
+		var err error
+		if devnam, err = enumerate_ports(); err != nil {
+			return nil, err
+		}
+	}
+	sp, err := msp.NewClient(ctx, devnam, true)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", devnam, err)
+	}
+	if err = sp.Negotiate(ctx); err != nil {
+		sp.Close()
+		return nil, fmt.Errorf("%s: %v", devnam, err)
+	}
+	return sp, nil
+}
+
 func main() {
 	devnam := ""
 	xsleep := false
-	mspvers := 2
+	mspvers := 0
 	show := false
+	ratespec := ""
+	config := ""
+	timeout := msp.DefaultTimeout
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "bridge":
+			run_bridge(os.Args[2:])
+			return
+		case "sniff":
+			run_sniff(os.Args[2:])
+			return
+		case "mission":
+			run_mission(os.Args[2:])
+			return
+		case "settings":
+			run_settings(os.Args[2:])
+			return
+		case "diff":
+			run_diff(os.Args[2:])
+			return
+		}
+	}
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of mspview [options] device\n")
+		fmt.Fprintf(os.Stderr, "       mspview bridge [options] device\n")
+		fmt.Fprintf(os.Stderr, "       mspview sniff [options] device [device ...]\n")
+		fmt.Fprintf(os.Stderr, "       mspview mission download [options] device\n")
+		fmt.Fprintf(os.Stderr, "       mspview mission upload [options] file device\n")
+		fmt.Fprintf(os.Stderr, "       mspview settings dump|restore [options] ...\n")
+		fmt.Fprintf(os.Stderr, "       mspview diff device file|device\n")
 		flag.PrintDefaults()
 	}
 
-	flag.IntVar(&mspvers, "mspversion", 2, "MSP Version")
-	flag.BoolVar(&xsleep, "slow", false, "Slow mode")
+	flag.IntVar(&mspvers, "mspversion", 0, "MSP Version (0 = negotiate)")
+	flag.BoolVar(&xsleep, "slow", false, "Slow mode (poll at most once per second)")
+	flag.StringVar(&ratespec, "rates", "", "Poll rates in Hz, e.g. attitude=20,gps=5,status=1")
+	flag.StringVar(&config, "config", "", "Config file with a [rates] section")
+	flag.DurationVar(&timeout, "timeout", msp.DefaultTimeout, "Request timeout, doubled on each retry")
 	flag.BoolVar(&show, "show-ports", false, "Enumerate ports")
 	flag.Parse()
 	files := flag.Args()
@@ -190,6 +262,20 @@ func main() {
 		devnam = "auto"
 	}
 
+	if config != "" {
+		if err := load_rates(config); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if err := parse_rates(ratespec); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if xsleep {
+		cap_rates(1)
+	}
+
 	s, err := tcell.NewScreen()
 	if err != nil {
 		fmt.Println(err)
@@ -221,14 +307,10 @@ func main() {
 		}
 	}()
 
-	nmsg := 0
+	ctx, cancel := context.WithCancel(context.Background())
 
-	var start time.Time
-	var sp *MSPSerial
-	c0 := make(chan SChan)
-
-	serok := false
 	rates := ""
+	summary := ""
 	bold := tcell.StyleDefault.Background(tcell.ColorReset).Foreground(tcell.ColorReset).Bold(true)
 
 	go func() {
@@ -240,197 +322,87 @@ func main() {
 			} else {
 				portnam = devnam
 			}
+			if err != nil {
+				done <- fmt.Sprintf("%v", err)
+				return
+			}
+			if msp.ParseDevice(portnam).Listen {
+				set_value(s, IY_PORT, "waiting for connection on "+portnam, defstyle)
+				s.Show()
+			}
+			sp, err := msp.NewClient(ctx, portnam, (mspvers != 1))
 			if err == nil {
-				sp, err = NewMSPSerial(portnam, c0, (mspvers == 2))
-				if err == nil {
-					clear_err(s)
-					set_value(s, IY_PORT, portnam, bold)
-					nmsg = 0
-					serok = true
-					sp.MSPCommand(Msp_IDENT)
+				sp.Timeout = timeout
+				var nerr error
+				if mspvers == 0 {
+					nerr = sp.Negotiate(ctx)
+				}
+				clear_err(s)
+				if nerr != nil {
+					// carry on with the default protocol, but say so
+					set_value(s, IY_PORT, fmt.Sprintf("%s (MSPv%d, not negotiated)", portnam, sp.Version()), bold)
+					drawText(s, 0, height-2, defstyle, fmt.Sprintf("MSP version negotiation failed: %v", nerr))
 				} else {
-					serok = false
+					set_value(s, IY_PORT, portnam, bold)
 				}
-				var tmsg time.Time
+				dbg := sp.Handle(msp.Msp_DEBUG, func(v msp.Frame) {
+					ds := strings.Trim(string(v.Data), "\x00\t\r\n ")
+					set_value(s, IY_DEBUG, ds, bold)
+					s.Show()
+				})
+				variant := identify(ctx, s, sp, bold)
+
+				sc := msp.NewScheduler(sp)
+				sc.OnError = func(cmd uint16, err error) {
+					clear_err(s)
+					drawText(s, 0, height-2, defstyle, fmt.Sprintf("%s: %v", msp.CmdName(cmd), err))
+					s.Show()
+				}
+				pl := schedule(s, sp, sc, variant == "INAV", bold)
+				go sc.Run(ctx)
+
+				start := time.Now()
+				var last msp.Stats
 				ticker := time.NewTicker(1 * time.Second)
-				nxt := uint16(0)
-				for serok {
+				for serok := true; serok; {
 					select {
-					case v := <-c0:
-						nmsg += 1
-						tmsg = time.Now()
-						switch v.cmd {
-						case Msp_IDENT:
-							start = time.Now()
-							if v.ok == sMSP_OK {
-								txt := fmt.Sprintf("MW Compat: %d, (msp protocol v%d)", v.data[0], mspvers)
-								set_value(s, IY_MW, txt, bold)
-							}
-							nxt = Msp_NAME
-						case Msp_NAME:
-							if v.ok == sMSP_OK && v.len > 0 {
-								set_value(s, IY_NAME, string(v.data), bold)
-							}
-							nxt = Msp_API_VERSION
-						case Msp_API_VERSION:
-							if v.ok == sMSP_OK && v.len > 2 {
-								txt := fmt.Sprintf("%d.%d (%d)", v.data[1], v.data[2], mspvers)
-								set_value(s, IY_APIV, txt, bold)
-							}
-							nxt = Msp_FC_VARIANT
-						case Msp_FC_VARIANT:
-							if v.ok == sMSP_OK {
-								set_value(s, IY_FC, string(v.data[0:4]), bold)
-							}
-							nxt = Msp_FC_VERSION
-						case Msp_FC_VERSION:
-							if v.ok == sMSP_OK {
-								txt := fmt.Sprintf("%d.%d.%d", v.data[0], v.data[1], v.data[2])
-								set_value(s, IY_FCVERS, txt, bold)
-							}
-							nxt = Msp_BUILD_INFO
-						case Msp_BUILD_INFO:
-							if v.ok == sMSP_OK {
-								txt := fmt.Sprintf("%s %s (%s)", string(v.data[0:11]),
-									string(v.data[11:19]), string(v.data[19:]))
-								set_value(s, IY_BUILD, txt, bold)
-							}
-							nxt = Msp_BOARD_INFO
-						case Msp_BOARD_INFO:
-							if v.ok == sMSP_OK {
-								var board string
-								if v.len > 8 {
-									board = string(v.data[9:])
-								} else {
-									board = string(v.data[0:4])
-								}
-								set_value(s, IY_BOARD, board, bold)
-							}
-							nxt = Msp_WP_GETINFO
-
-						case Msp_WP_GETINFO:
-							if v.ok == sMSP_OK {
-								wp_max := v.data[1]
-								wp_valid := v.data[2]
-								wp_count := v.data[3]
-								txt := fmt.Sprintf("%d of %d, valid %v", wp_count, wp_max, (wp_valid != 0))
-								set_value(s, IY_WPINFO, txt, bold)
-							}
-							if mspvers == 2 {
-								nxt = Msp_MISC2
-							} else {
-								nxt = Msp_ANALOG
-							}
-
-						case Msp_ANALOG:
-							if v.ok == sMSP_OK {
-								volts := float64(v.data[0]) / 10.0
-								amps := float64(binary.LittleEndian.Uint16(v.data[5:7])) / 100.0
-								txt := fmt.Sprintf("volts: %.1f, amps: %.2f", volts, amps)
-								set_value(s, IY_ANALOG, txt, bold)
-							}
-							nxt = Msp_STATUS_EX
-
-						case Msp_MISC2:
-							if v.ok == sMSP_OK {
-								uptime := binary.LittleEndian.Uint32(v.data[0:4])
-								txt := fmt.Sprintf("%ds", uptime)
-								set_value(s, IY_UPTIME, txt, bold)
-							}
-							nxt = Msp_ANALOG2
-
-						case Msp_ANALOG2:
-							if v.ok == sMSP_OK {
-								volts := float64(binary.LittleEndian.Uint16(v.data[1:3])) / 100.0
-								amps := float64(binary.LittleEndian.Uint16(v.data[3:5])) / 100.0
-								txt := fmt.Sprintf("volts: %.1f, amps: %.2f", volts, amps)
-								set_value(s, IY_ANALOG, txt, bold)
-							}
-							nxt = Msp_INAV_STATUS
-
-						case Msp_INAV_STATUS:
-							if v.ok == sMSP_OK {
-								armf := binary.LittleEndian.Uint32(v.data[9:13])
-								txt := arm_status(armf)
-								set_value(s, IY_ARM, txt, bold)
-								nxt = Msp_RAW_GPS
-							} else {
-								nxt = Msp_STATUS_EX
-							}
-						case Msp_STATUS_EX:
-							if v.ok == sMSP_OK {
-								armf := binary.LittleEndian.Uint16(v.data[13:15])
-								txt := arm_status(uint32(armf))
-								set_value(s, IY_ARM, txt, bold)
-							}
-							nxt = Msp_RAW_GPS
-
-						case Msp_RAW_GPS:
-							if v.ok == sMSP_OK {
-								fix := v.data[0]
-								nsat := v.data[1]
-								lat := float64(int32(binary.LittleEndian.Uint32(v.data[2:6]))) / 1e7
-								lon := float64(int32(binary.LittleEndian.Uint32(v.data[6:10]))) / 1e7
-								alt := int16(binary.LittleEndian.Uint16(v.data[10:12]))
-								spd := float64(binary.LittleEndian.Uint16(v.data[12:14])) / 100.0
-								cog := float64(binary.LittleEndian.Uint16(v.data[14:16])) / 10.0
-								txt := fmt.Sprintf("fix %d, sats %d,  %.6f° %.6f° %dm, %.0fm/s %.0f°", fix, nsat, lat, lon, alt, spd, cog)
-								if len(v.data) > 16 {
-									hdop := float64(binary.LittleEndian.Uint16(v.data[16:18])) / 100.0
-									txt = txt + fmt.Sprintf(" hdop %.2f", hdop)
-								}
-								set_value(s, IY_GPS, txt, bold)
-							}
-							dura := time.Since(start).Seconds()
-							rate := float64(nmsg) / dura
-							rates = fmt.Sprintf("%d messages in %.2fs (%.1f/s)", nmsg, dura, rate)
-							set_value(s, IY_RATE, rates, bold)
-							if xsleep {
-								time.Sleep(time.Second * 1)
-							}
-							if mspvers == 2 {
-								nxt = Msp_MISC2
-							} else {
-								nxt = Msp_ANALOG
-							}
-
-						case Msp_DEBUG:
-							ds := strings.Trim(string(v.data), "\x00\t\r\n ")
-							set_value(s, IY_DEBUG, ds, bold)
-							nxt = 0
-						default:
-							serok = false
-							sp = nil
-							nxt = 0
-							s.Clear()
-							show_prompts(s)
-							if v.ok != sMSP_OK {
-								if v.len > 0 {
-									drawText(s, 0, height-2, defstyle, string(v.data))
-								}
-							}
+					case <-ticker.C:
+						st := sp.Stats()
+						dura := time.Since(start).Seconds()
+						rates = fmt.Sprintf("%d messages in %.2fs (%.1f/s)", st.Frames, dura, float64(st.Frames)/dura)
+						set_value(s, IY_RATE, rates+" "+achieved(sc, pl), bold)
+						txt := fmt.Sprintf("%d ok, %d CRC errors, %d bytes discarded, %d oversize, %d dropped", st.Frames, st.CRCErrors, st.Discarded, st.Oversize, dbg.Dropped())
+						set_value(s, IY_LINK, txt, bold)
+						bps := float64(st.RxBytes + st.TxBytes - last.RxBytes - last.TxBytes)
+						set_value(s, IY_LATENCY, latency_line(sp, st, bps), bold)
+						summary = link_summary(sp, time.Since(start))
+						last = st
+						if txt := stalled(sc); txt == "" {
+							set_value(s, IY_STALL, "none", defstyle)
+							clear_err(s)
+						} else {
+							set_value(s, IY_STALL, txt, tcell.StyleDefault.Foreground(tcell.ColorRed))
 						}
 						s.Show()
-						if nxt != 0 {
-							sp.MSPCommand(nxt)
-						}
-					case t := <-ticker.C:
-						if t.Sub(tmsg) > 2*time.Second {
-							str := fmt.Sprintf("Timeout on %d", nxt)
-							drawText(s, 0, height-2, defstyle, str)
-						}
-					} // select
-				} // serok
-				time.Sleep(1 * time.Second)
-			} else {
-				done <- fmt.Sprintf("%v", err)
-			} // err
-		} // outer
-	}() // func
+					case <-sp.Done():
+						serok = false
+						s.Clear()
+						show_prompts(s)
+						drawText(s, 0, height-2, defstyle, sp.Err().Error())
+						s.Show()
+					}
+				}
+				ticker.Stop()
+			}
+			time.Sleep(1 * time.Second)
+		}
+	}()
 	ecode := <-done
+	cancel()
 	s.Fini()
 	if ecode == "" {
 		fmt.Println(rates)
+		fmt.Print(summary)
 	} else {
 		fmt.Println(ecode)
 	}
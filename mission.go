@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/stronnag/msp-go/mission"
+	"io"
+	"log"
+	"os"
+)
+
+func mission_download(args []string) {
+	fs := flag.NewFlagSet("mission download", flag.ExitOnError)
+	ofile := fs.String("o", "", "Output file (default stdout)")
+	format := fs.String("format", "", "Output format: xml, json, gpx or kml (default from the file name, else xml)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of mspview mission download [options] device\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *format == "" {
+		*format = mission.FormatOf(*ofile)
+	}
+	ctx := context.Background()
+	sp, err := open_fc(ctx, fs.Arg(0))
+	if err != nil {
+		log.Fatalf("mission: %v", err)
+	}
+	defer sp.Close()
+	wps, err := sp.DownloadMission(ctx)
+	if err != nil {
+		log.Fatalf("mission: %v", err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *ofile != "" {
+		fh, err := os.Create(*ofile)
+		if err != nil {
+			log.Fatalf("mission: %v", err)
+		}
+		defer fh.Close()
+		w = fh
+	}
+	if err = mission.Write(w, *format, wps); err != nil {
+		log.Fatalf("mission: %v", err)
+	}
+	log.Printf("mission: %d waypoints", len(wps))
+}
+
+func mission_upload(args []string) {
+	fs := flag.NewFlagSet("mission upload", flag.ExitOnError)
+	format := fs.String("format", "", "Input format: xml or json (default from the file name, else xml)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of mspview mission upload [options] file device\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	ifile := fs.Arg(0)
+	if *format == "" {
+		*format = mission.FormatOf(ifile)
+	}
+	fh, err := os.Open(ifile)
+	if err != nil {
+		log.Fatalf("mission: %v", err)
+	}
+	wps, err := mission.Read(fh, *format)
+	fh.Close()
+	if err != nil {
+		log.Fatalf("mission: %s: %v", ifile, err)
+	}
+
+	ctx := context.Background()
+	sp, err := open_fc(ctx, fs.Arg(1))
+	if err != nil {
+		log.Fatalf("mission: %v", err)
+	}
+	defer sp.Close()
+	if err = sp.UploadMission(ctx, wps); err != nil {
+		log.Fatalf("mission: upload: %v", err)
+	}
+	log.Printf("mission: %d waypoints uploaded", len(wps))
+	if err = sp.VerifyMission(ctx, wps); err != nil {
+		log.Fatalf("mission: verify: %v", err)
+	}
+	log.Printf("mission: verified, FC reports mission valid")
+}
+
+func run_mission(args []string) {
+	if len(args) > 0 {
+		switch args[0] {
+		case "download":
+			mission_download(args[1:])
+			return
+		case "upload":
+			mission_upload(args[1:])
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Usage of mspview mission download [options] device\n")
+	fmt.Fprintf(os.Stderr, "       mspview mission upload [options] file device\n")
+	os.Exit(1)
+}
@@ -0,0 +1,51 @@
+package mission
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/stronnag/msp-go/msp"
+	"io"
+)
+
+type gpxDoc struct {
+	XMLName xml.Name `xml:"gpx"`
+	Version string   `xml:"version,attr"`
+	Creator string   `xml:"creator,attr"`
+	NS      string   `xml:"xmlns,attr"`
+	Route   gpxRoute `xml:"rte"`
+}
+
+type gpxRoute struct {
+	Name   string     `xml:"name"`
+	Points []gpxPoint `xml:"rtept"`
+}
+
+type gpxPoint struct {
+	Lat  float64 `xml:"lat,attr"`
+	Lon  float64 `xml:"lon,attr"`
+	Ele  float64 `xml:"ele"`
+	Name string  `xml:"name"`
+	Type string  `xml:"type"`
+}
+
+// WriteGPX writes the waypoints that have a position as a GPX route.
+func WriteGPX(w io.Writer, wps []msp.Waypoint) error {
+	g := gpxDoc{Version: "1.1", Creator: "mspview", NS: "http://www.topografix.com/GPX/1/1",
+		Route: gpxRoute{Name: "Mission"}}
+	for _, wp := range wps {
+		if wp.Geo() {
+			g.Route.Points = append(g.Route.Points, gpxPoint{Lat: degrees(wp.Lat), Lon: degrees(wp.Lon),
+				Ele: metres(wp.Alt), Name: fmt.Sprintf("WP%d", wp.No), Type: msp.WPActionName(wp.Action)})
+		}
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(g); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,74 @@
+package mission
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/stronnag/msp-go/msp"
+	"io"
+	"time"
+)
+
+// INAV / mwp mission XML; lat and lon are degrees, alt metres.
+type xmlMission struct {
+	XMLName xml.Name  `xml:"mission"`
+	Version xmlValue  `xml:"version"`
+	MWP     *xmlMWP   `xml:"mwp,omitempty"`
+	Items   []xmlItem `xml:"missionitem"`
+}
+
+type xmlValue struct {
+	Value string `xml:"value,attr"`
+}
+
+type xmlMWP struct {
+	SaveDate  string `xml:"save-date,attr,omitempty"`
+	Generator string `xml:"generator,attr,omitempty"`
+}
+
+type xmlItem struct {
+	No     int     `xml:"no,attr"`
+	Action string  `xml:"action,attr"`
+	Lat    float64 `xml:"lat,attr"`
+	Lon    float64 `xml:"lon,attr"`
+	Alt    float64 `xml:"alt,attr"`
+	P1     int16   `xml:"parameter1,attr"`
+	P2     int16   `xml:"parameter2,attr"`
+	P3     int16   `xml:"parameter3,attr"`
+	Flag   uint8   `xml:"flag,attr"`
+}
+
+func WriteXML(w io.Writer, wps []msp.Waypoint) error {
+	m := xmlMission{Version: xmlValue{"2.3-pre8"},
+		MWP: &xmlMWP{SaveDate: time.Now().Format(time.RFC3339), Generator: "mspview"}}
+	for _, wp := range wps {
+		m.Items = append(m.Items, xmlItem{No: int(wp.No), Action: msp.WPActionName(wp.Action),
+			Lat: degrees(wp.Lat), Lon: degrees(wp.Lon), Alt: metres(wp.Alt),
+			P1: wp.P1, P2: wp.P2, P3: wp.P3, Flag: wp.Flag})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func ReadXML(r io.Reader) ([]msp.Waypoint, error) {
+	var m xmlMission
+	if err := xml.NewDecoder(r).Decode(&m); err != nil {
+		return nil, err
+	}
+	var wps []msp.Waypoint
+	for _, it := range m.Items {
+		wp, err := waypoint(it.Action, it.Lat, it.Lon, it.Alt, it.P1, it.P2, it.P3, it.Flag)
+		if err != nil {
+			return nil, fmt.Errorf("mission: item %d: %v", it.No, err)
+		}
+		wps = append(wps, wp)
+	}
+	return wps, nil
+}
@@ -0,0 +1,53 @@
+package mission
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stronnag/msp-go/msp"
+	"io"
+)
+
+// mwp style JSON mission; lat and lon are degrees, alt metres.
+type jsonMission struct {
+	Mission []jsonItem `json:"mission"`
+}
+
+type jsonItem struct {
+	No     int     `json:"no"`
+	Action string  `json:"action"`
+	Lat    float64 `json:"lat"`
+	Lon    float64 `json:"lon"`
+	Alt    float64 `json:"alt"`
+	P1     int16   `json:"p1"`
+	P2     int16   `json:"p2"`
+	P3     int16   `json:"p3"`
+	Flag   uint8   `json:"flag"`
+}
+
+func WriteJSON(w io.Writer, wps []msp.Waypoint) error {
+	m := jsonMission{Mission: []jsonItem{}}
+	for _, wp := range wps {
+		m.Mission = append(m.Mission, jsonItem{No: int(wp.No), Action: msp.WPActionName(wp.Action),
+			Lat: degrees(wp.Lat), Lon: degrees(wp.Lon), Alt: metres(wp.Alt),
+			P1: wp.P1, P2: wp.P2, P3: wp.P3, Flag: wp.Flag})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+func ReadJSON(r io.Reader) ([]msp.Waypoint, error) {
+	var m jsonMission
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, err
+	}
+	var wps []msp.Waypoint
+	for _, it := range m.Mission {
+		wp, err := waypoint(it.Action, it.Lat, it.Lon, it.Alt, it.P1, it.P2, it.P3, it.Flag)
+		if err != nil {
+			return nil, fmt.Errorf("mission: item %d: %v", it.No, err)
+		}
+		wps = append(wps, wp)
+	}
+	return wps, nil
+}
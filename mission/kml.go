@@ -0,0 +1,69 @@
+package mission
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/stronnag/msp-go/msp"
+	"io"
+	"strings"
+)
+
+type kmlDoc struct {
+	XMLName xml.Name    `xml:"kml"`
+	NS      string      `xml:"xmlns,attr"`
+	Doc     kmlDocument `xml:"Document"`
+}
+
+type kmlDocument struct {
+	Name       string         `xml:"name"`
+	Placemarks []kmlPlacemark `xml:"Placemark"`
+}
+
+type kmlPlacemark struct {
+	Name        string       `xml:"name"`
+	Description string       `xml:"description,omitempty"`
+	Point       *kmlGeometry `xml:"Point,omitempty"`
+	LineString  *kmlGeometry `xml:"LineString,omitempty"`
+}
+
+type kmlGeometry struct {
+	AltitudeMode string `xml:"altitudeMode"`
+	Coordinates  string `xml:"coordinates"`
+}
+
+// WriteKML writes the waypoints that have a position as placemarks joined by
+// the route. INAV altitudes are relative to home unless parameter 3 bit 0
+// marks them as AMSL.
+func WriteKML(w io.Writer, wps []msp.Waypoint) error {
+	k := kmlDoc{NS: "http://www.opengis.net/kml/2.2", Doc: kmlDocument{Name: "Mission"}}
+	var route []string
+	amode := "absolute"
+	for _, wp := range wps {
+		if wp.Geo() && wp.P3&1 == 0 {
+			amode = "relativeToGround"
+		}
+	}
+	for _, wp := range wps {
+		if !wp.Geo() {
+			continue
+		}
+		c := fmt.Sprintf("%.7f,%.7f,%.2f", degrees(wp.Lon), degrees(wp.Lat), metres(wp.Alt))
+		route = append(route, c)
+		k.Doc.Placemarks = append(k.Doc.Placemarks, kmlPlacemark{Name: fmt.Sprintf("WP%d", wp.No),
+			Description: msp.WPActionName(wp.Action), Point: &kmlGeometry{amode, c}})
+	}
+	if len(route) > 1 {
+		k.Doc.Placemarks = append(k.Doc.Placemarks, kmlPlacemark{Name: "Route",
+			LineString: &kmlGeometry{amode, strings.Join(route, " ")}})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(k); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
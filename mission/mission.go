@@ -0,0 +1,94 @@
+// Package mission reads and writes waypoint missions, as transferred with
+// MSP_WP / MSP_SET_WP, in the file formats used by INAV tools and mapping
+// applications.
+package mission
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stronnag/msp-go/msp"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	Format_XML  = "xml"
+	Format_GPX  = "gpx"
+	Format_KML  = "kml"
+	Format_JSON = "json"
+)
+
+// FormatOf returns the format implied by a file name's extension, or
+// Format_XML, INAV's native format, if there is none.
+func FormatOf(fname string) string {
+	switch ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(fname), ".")); ext {
+	case Format_GPX, Format_KML, Format_JSON:
+		return ext
+	}
+	return Format_XML
+}
+
+// Write writes the mission wps to w in format.
+func Write(w io.Writer, format string, wps []msp.Waypoint) error {
+	switch format {
+	case Format_XML:
+		return WriteXML(w, wps)
+	case Format_GPX:
+		return WriteGPX(w, wps)
+	case Format_KML:
+		return WriteKML(w, wps)
+	case Format_JSON:
+		return WriteJSON(w, wps)
+	}
+	return fmt.Errorf("mission: unknown format %q", format)
+}
+
+// Read reads a mission in format, which must be Format_XML or Format_JSON.
+// The waypoints are numbered from 1 and the last is flagged
+// msp.WP_FLAG_LAST, ready for upload.
+func Read(r io.Reader, format string) ([]msp.Waypoint, error) {
+	var wps []msp.Waypoint
+	var err error
+	switch format {
+	case Format_XML:
+		wps, err = ReadXML(r)
+	case Format_JSON:
+		wps, err = ReadJSON(r)
+	default:
+		return nil, fmt.Errorf("mission: cannot read %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(wps) == 0 {
+		return nil, errors.New("mission: no waypoints")
+	}
+	for j := range wps {
+		wps[j].No = uint8(j + 1)
+		if wps[j].Flag == msp.WP_FLAG_LAST {
+			wps[j].Flag = 0
+		}
+	}
+	wps[len(wps)-1].Flag = msp.WP_FLAG_LAST
+	return wps, nil
+}
+
+// waypoint converts a file's item, in degrees and metres, to a Waypoint.
+func waypoint(action string, lat, lon, alt float64, p1, p2, p3 int16, flag uint8) (msp.Waypoint, error) {
+	a, ok := msp.WPAction(strings.ToUpper(action))
+	if !ok {
+		return msp.Waypoint{}, fmt.Errorf("unknown action %q", action)
+	}
+	return msp.Waypoint{Action: a, Lat: int32(math.Round(lat * 1e7)), Lon: int32(math.Round(lon * 1e7)),
+		Alt: int32(math.Round(alt * 100)), P1: p1, P2: p2, P3: p3, Flag: flag}, nil
+}
+
+func degrees(v int32) float64 {
+	return float64(v) / 1e7
+}
+
+func metres(cm int32) float64 {
+	return float64(cm) / 100.0
+}
@@ -0,0 +1,51 @@
+package msp
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// btSocket is the RFCOMM socket layer; the system implementation may be
+// replaced by anything returning a connected stream fd (e.g. one end of a
+// socketpair) to exercise the transport without a radio.
+type btSocket interface {
+	Socket() (int, error)
+	Connect(fd int, addr [6]byte, channel uint8) error
+	Close(fd int) error
+}
+
+// parse_mac returns the address in the kernel's bdaddr_t order, last octet
+// first.
+func parse_mac(s string) ([6]byte, error) {
+	var addr [6]byte
+	parts := strings.Split(s, ":")
+	if len(parts) != 6 {
+		return addr, fmt.Errorf("invalid bluetooth address %s", s)
+	}
+	for j, p := range parts {
+		b, err := strconv.ParseUint(p, 16, 8)
+		if err != nil {
+			return addr, fmt.Errorf("invalid bluetooth address %s", s)
+		}
+		addr[5-j] = byte(b)
+	}
+	return addr, nil
+}
+
+func open_bt(bs btSocket, name string, channel int) (Transport, error) {
+	addr, err := parse_mac(name)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := bs.Socket()
+	if err != nil {
+		return nil, err
+	}
+	if err = bs.Connect(fd, addr, uint8(channel)); err != nil {
+		bs.Close(fd)
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), name), nil
+}
@@ -0,0 +1,25 @@
+//go:build linux
+
+package msp
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+type sysBTSocket struct{}
+
+func (sysBTSocket) Socket() (int, error) {
+	return unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_STREAM, unix.BTPROTO_RFCOMM)
+}
+
+func (sysBTSocket) Connect(fd int, addr [6]byte, channel uint8) error {
+	err := unix.Connect(fd, &unix.SockaddrRFCOMM{Addr: addr, Channel: channel})
+	if err == nil {
+		err = unix.SetNonblock(fd, true)
+	}
+	return err
+}
+
+func (sysBTSocket) Close(fd int) error {
+	return unix.Close(fd)
+}
@@ -0,0 +1,71 @@
+//go:build linux
+
+package msp
+
+import (
+	"bytes"
+	"context"
+	"golang.org/x/sys/unix"
+	"os"
+	"testing"
+)
+
+// pairBTSocket connects to the other end of a socketpair instead of a radio.
+type pairBTSocket struct {
+	peer    int
+	addr    [6]byte
+	channel uint8
+}
+
+func (s *pairBTSocket) Socket() (int, error) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err != nil {
+		return -1, err
+	}
+	s.peer = fds[1]
+	return fds[0], nil
+}
+
+func (s *pairBTSocket) Connect(fd int, addr [6]byte, channel uint8) error {
+	s.addr = addr
+	s.channel = channel
+	return unix.SetNonblock(fd, true)
+}
+
+func (s *pairBTSocket) Close(fd int) error {
+	return unix.Close(fd)
+}
+
+func TestBTRequest(t *testing.T) {
+	bs := &pairBTSocket{}
+	tr, err := open_bt(bs, "00:1a:7d:da:71:0b", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bs.addr != [6]byte{0x0b, 0x71, 0xda, 0x7d, 0x1a, 0x00} || bs.channel != 3 {
+		t.Errorf("connected to %x channel %d", bs.addr, bs.channel)
+	}
+	fc := os.NewFile(uintptr(bs.peer), "fc")
+	defer fc.Close()
+	pl := test_payload(300)
+	go echo_fc(fc, pl, 16)
+
+	sp := new_client(context.Background(), tr, true, true)
+	defer sp.Close()
+	f, err := sp.Request(context.Background(), Msp_NAME, nil)
+	if err != nil || f.Cmd != Msp_NAME || !bytes.Equal(f.Data, pl) {
+		t.Errorf("cmd %d len %d err %v", f.Cmd, len(f.Data), err)
+	}
+}
+
+func TestBTBadAddress(t *testing.T) {
+	for _, s := range []string{"", "00:1a:7d:da:71", "00:1a:7d:da:71:0b:01", "00:1a:7d:da:71:zz", "00:1a:7d:da:71:100"} {
+		bs := &pairBTSocket{peer: -1}
+		if _, err := open_bt(bs, s, 1); err == nil {
+			t.Errorf("%q accepted", s)
+		}
+		if bs.peer != -1 {
+			t.Errorf("%q: socket opened", s)
+		}
+	}
+}
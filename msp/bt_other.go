@@ -0,0 +1,22 @@
+//go:build !linux
+
+package msp
+
+import (
+	"fmt"
+	"runtime"
+)
+
+type sysBTSocket struct{}
+
+func (sysBTSocket) Socket() (int, error) {
+	return -1, fmt.Errorf("bluetooth is not supported on %s", runtime.GOOS)
+}
+
+func (sysBTSocket) Connect(fd int, addr [6]byte, channel uint8) error {
+	return nil
+}
+
+func (sysBTSocket) Close(fd int) error {
+	return nil
+}
@@ -0,0 +1,81 @@
+package msp
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// Captures are pcap files (LINKTYPE_USER0), one raw MSP frame per record,
+// so they may also be opened in wireshark.
+const (
+	pcap_MAGIC   = 0xa1b2c3d4
+	pcap_USER0   = 147
+	pcap_SNAPLEN = 0x10000 + 16
+)
+
+var ErrNotCapture = errors.New("not a MSP capture file")
+
+type CaptureWriter struct {
+	w io.Writer
+}
+
+func NewCaptureWriter(w io.Writer) (*CaptureWriter, error) {
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr[0:4], pcap_MAGIC)
+	binary.LittleEndian.PutUint16(hdr[4:6], 2)
+	binary.LittleEndian.PutUint16(hdr[6:8], 4)
+	binary.LittleEndian.PutUint32(hdr[16:20], pcap_SNAPLEN)
+	binary.LittleEndian.PutUint32(hdr[20:24], pcap_USER0)
+	_, err := w.Write(hdr)
+	if err != nil {
+		return nil, err
+	}
+	return &CaptureWriter{w: w}, nil
+}
+
+func (c *CaptureWriter) WriteFrame(t time.Time, raw []byte) error {
+	hdr := make([]byte, 16)
+	binary.LittleEndian.PutUint32(hdr[0:4], uint32(t.Unix()))
+	binary.LittleEndian.PutUint32(hdr[4:8], uint32(t.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(hdr[8:12], uint32(len(raw)))
+	binary.LittleEndian.PutUint32(hdr[12:16], uint32(len(raw)))
+	_, err := c.w.Write(append(hdr, raw...))
+	return err
+}
+
+type CaptureReader struct {
+	r io.Reader
+}
+
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	hdr := make([]byte, 24)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(hdr[0:4]) != pcap_MAGIC ||
+		binary.LittleEndian.Uint32(hdr[20:24]) != pcap_USER0 {
+		return nil, ErrNotCapture
+	}
+	return &CaptureReader{r: r}, nil
+}
+
+// Next returns the next record; io.EOF at the end of the capture.
+func (c *CaptureReader) Next() (time.Time, []byte, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(c.r, hdr); err != nil {
+		return time.Time{}, nil, err
+	}
+	t := time.Unix(int64(binary.LittleEndian.Uint32(hdr[0:4])),
+		int64(binary.LittleEndian.Uint32(hdr[4:8]))*1000)
+	n := binary.LittleEndian.Uint32(hdr[8:12])
+	if n > pcap_SNAPLEN {
+		return t, nil, ErrNotCapture
+	}
+	raw := make([]byte, n)
+	if _, err := io.ReadFull(c.r, raw); err != nil {
+		return t, nil, io.ErrUnexpectedEOF
+	}
+	return t, raw, nil
+}
@@ -0,0 +1,322 @@
+package msp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrShortPayload = errors.New("short payload")
+
+// Unmarshaler is implemented by the decoded message types.
+type Unmarshaler interface {
+	Unmarshal([]byte) error
+}
+
+func need(b []byte, n int) error {
+	if len(b) < n {
+		return fmt.Errorf("%w: need %d bytes, got %d", ErrShortPayload, n, len(b))
+	}
+	return nil
+}
+
+type Ident struct {
+	Version uint8
+}
+
+func (m *Ident) Unmarshal(b []byte) error {
+	if err := need(b, 1); err != nil {
+		return err
+	}
+	m.Version = b[0]
+	return nil
+}
+
+type Name struct {
+	Name string
+}
+
+func (m *Name) Unmarshal(b []byte) error {
+	m.Name = strings.TrimRight(string(b), "\x00")
+	return nil
+}
+
+type APIVersion struct {
+	Protocol uint8
+	Major    uint8
+	Minor    uint8
+}
+
+func (m *APIVersion) Unmarshal(b []byte) error {
+	if err := need(b, 3); err != nil {
+		return err
+	}
+	m.Protocol = b[0]
+	m.Major = b[1]
+	m.Minor = b[2]
+	return nil
+}
+
+type FCVariant struct {
+	Variant string
+}
+
+func (m *FCVariant) Unmarshal(b []byte) error {
+	if err := need(b, 4); err != nil {
+		return err
+	}
+	m.Variant = string(b[0:4])
+	return nil
+}
+
+type FCVersion struct {
+	Major uint8
+	Minor uint8
+	Patch uint8
+}
+
+func (m *FCVersion) Unmarshal(b []byte) error {
+	if err := need(b, 3); err != nil {
+		return err
+	}
+	m.Major = b[0]
+	m.Minor = b[1]
+	m.Patch = b[2]
+	return nil
+}
+
+type BuildInfo struct {
+	Date     string
+	Time     string
+	Revision string
+}
+
+func (m *BuildInfo) Unmarshal(b []byte) error {
+	if err := need(b, 19); err != nil {
+		return err
+	}
+	m.Date = string(b[0:11])
+	m.Time = string(b[11:19])
+	m.Revision = string(b[19:])
+	return nil
+}
+
+type BoardInfo struct {
+	Identifier string
+	Name       string
+}
+
+func (m *BoardInfo) Unmarshal(b []byte) error {
+	if err := need(b, 4); err != nil {
+		return err
+	}
+	m.Identifier = string(b[0:4])
+	m.Name = ""
+	if len(b) > 8 {
+		m.Name = string(b[9:])
+	}
+	return nil
+}
+
+// Board returns the board name if the FC reports it, else the identifier.
+func (m *BoardInfo) Board() string {
+	if m.Name != "" {
+		return m.Name
+	}
+	return m.Identifier
+}
+
+type WPInfo struct {
+	Capabilities uint8
+	MaxWaypoints uint8
+	Valid        bool
+	Count        uint8
+}
+
+func (m *WPInfo) Unmarshal(b []byte) error {
+	if err := need(b, 4); err != nil {
+		return err
+	}
+	m.Capabilities = b[0]
+	m.MaxWaypoints = b[1]
+	m.Valid = b[2] != 0
+	m.Count = b[3]
+	return nil
+}
+
+// Analog is MSP_ANALOG (v1)
+type Analog struct {
+	Volts    float64
+	MAhDrawn uint16
+	RSSI     uint16
+	Amps     float64
+}
+
+func (m *Analog) Unmarshal(b []byte) error {
+	if err := need(b, 7); err != nil {
+		return err
+	}
+	m.Volts = float64(b[0]) / 10.0
+	m.MAhDrawn = binary.LittleEndian.Uint16(b[1:3])
+	m.RSSI = binary.LittleEndian.Uint16(b[3:5])
+	m.Amps = float64(binary.LittleEndian.Uint16(b[5:7])) / 100.0
+	return nil
+}
+
+// Analog2 is MSP2_INAV_ANALOG
+type Analog2 struct {
+	BattFlags uint8
+	Volts     float64
+	Amps      float64
+}
+
+func (m *Analog2) Unmarshal(b []byte) error {
+	if err := need(b, 5); err != nil {
+		return err
+	}
+	m.BattFlags = b[0]
+	m.Volts = float64(binary.LittleEndian.Uint16(b[1:3])) / 100.0
+	m.Amps = float64(binary.LittleEndian.Uint16(b[3:5])) / 100.0
+	return nil
+}
+
+// InavStatus is MSP2_INAV_STATUS
+type InavStatus struct {
+	CycleTime   uint16
+	I2CErrors   uint16
+	Sensors     uint16
+	CPULoad     uint16
+	Profile     uint8
+	ArmingFlags uint32
+}
+
+func (m *InavStatus) Unmarshal(b []byte) error {
+	if err := need(b, 13); err != nil {
+		return err
+	}
+	m.CycleTime = binary.LittleEndian.Uint16(b[0:2])
+	m.I2CErrors = binary.LittleEndian.Uint16(b[2:4])
+	m.Sensors = binary.LittleEndian.Uint16(b[4:6])
+	m.CPULoad = binary.LittleEndian.Uint16(b[6:8])
+	m.Profile = b[8]
+	m.ArmingFlags = binary.LittleEndian.Uint32(b[9:13])
+	return nil
+}
+
+// StatusEx is MSP_STATUS_EX
+type StatusEx struct {
+	CycleTime   uint16
+	I2CErrors   uint16
+	Sensors     uint16
+	Flags       uint32
+	Profile     uint8
+	CPULoad     uint16
+	ArmingFlags uint16
+}
+
+func (m *StatusEx) Unmarshal(b []byte) error {
+	if err := need(b, 15); err != nil {
+		return err
+	}
+	m.CycleTime = binary.LittleEndian.Uint16(b[0:2])
+	m.I2CErrors = binary.LittleEndian.Uint16(b[2:4])
+	m.Sensors = binary.LittleEndian.Uint16(b[4:6])
+	m.Flags = binary.LittleEndian.Uint32(b[6:10])
+	m.Profile = b[10]
+	m.CPULoad = binary.LittleEndian.Uint16(b[11:13])
+	m.ArmingFlags = binary.LittleEndian.Uint16(b[13:15])
+	return nil
+}
+
+// Misc2 is MSP2_INAV_MISC2
+type Misc2 struct {
+	Uptime uint32
+}
+
+func (m *Misc2) Unmarshal(b []byte) error {
+	if err := need(b, 4); err != nil {
+		return err
+	}
+	m.Uptime = binary.LittleEndian.Uint32(b[0:4])
+	return nil
+}
+
+type Attitude struct {
+	Roll    float64
+	Pitch   float64
+	Heading int16
+}
+
+func (m *Attitude) Unmarshal(b []byte) error {
+	if err := need(b, 6); err != nil {
+		return err
+	}
+	m.Roll = float64(int16(binary.LittleEndian.Uint16(b[0:2]))) / 10.0
+	m.Pitch = float64(int16(binary.LittleEndian.Uint16(b[2:4]))) / 10.0
+	m.Heading = int16(binary.LittleEndian.Uint16(b[4:6]))
+	return nil
+}
+
+type RawGPS struct {
+	Fix     uint8
+	NumSat  uint8
+	Lat     float64
+	Lon     float64
+	Alt     int16
+	Speed   float64
+	Course  float64
+	HDOP    float64
+	HasHDOP bool
+}
+
+func (m *RawGPS) Unmarshal(b []byte) error {
+	if err := need(b, 16); err != nil {
+		return err
+	}
+	m.Fix = b[0]
+	m.NumSat = b[1]
+	m.Lat = float64(int32(binary.LittleEndian.Uint32(b[2:6]))) / 1e7
+	m.Lon = float64(int32(binary.LittleEndian.Uint32(b[6:10]))) / 1e7
+	m.Alt = int16(binary.LittleEndian.Uint16(b[10:12]))
+	m.Speed = float64(binary.LittleEndian.Uint16(b[12:14])) / 100.0
+	m.Course = float64(binary.LittleEndian.Uint16(b[14:16])) / 10.0
+	m.HasHDOP = len(b) >= 18
+	if m.HasHDOP {
+		m.HDOP = float64(binary.LittleEndian.Uint16(b[16:18])) / 100.0
+	} else {
+		m.HDOP = 0
+	}
+	return nil
+}
+
+var decoders = map[uint16]func() Unmarshaler{
+	Msp_IDENT:               func() Unmarshaler { return &Ident{} },
+	Msp_NAME:                func() Unmarshaler { return &Name{} },
+	Msp_API_VERSION:         func() Unmarshaler { return &APIVersion{} },
+	Msp_FC_VARIANT:          func() Unmarshaler { return &FCVariant{} },
+	Msp_FC_VERSION:          func() Unmarshaler { return &FCVersion{} },
+	Msp_BUILD_INFO:          func() Unmarshaler { return &BuildInfo{} },
+	Msp_BOARD_INFO:          func() Unmarshaler { return &BoardInfo{} },
+	Msp_WP_GETINFO:          func() Unmarshaler { return &WPInfo{} },
+	Msp_WP:                  func() Unmarshaler { return &Waypoint{} },
+	Msp_ANALOG:              func() Unmarshaler { return &Analog{} },
+	Msp_ANALOG2:             func() Unmarshaler { return &Analog2{} },
+	Msp_INAV_STATUS:         func() Unmarshaler { return &InavStatus{} },
+	Msp_STATUS_EX:           func() Unmarshaler { return &StatusEx{} },
+	Msp_MISC2:               func() Unmarshaler { return &Misc2{} },
+	Msp_RAW_GPS:             func() Unmarshaler { return &RawGPS{} },
+	Msp_ATTITUDE:            func() Unmarshaler { return &Attitude{} },
+	Msp_COMMON_SETTING_INFO: func() Unmarshaler { return &SettingInfo{} },
+}
+
+// Decode unmarshals the reply payload for cmd into its message type. It
+// returns nil, nil for commands without a decoder.
+func Decode(cmd uint16, data []byte) (Unmarshaler, error) {
+	if fn, ok := decoders[cmd]; ok {
+		m := fn()
+		err := m.Unmarshal(data)
+		return m, err
+	}
+	return nil, nil
+}
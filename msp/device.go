@@ -0,0 +1,143 @@
+package msp
+
+import (
+	"context"
+	"errors"
+	"github.com/albenik/go-serial/v2"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	DevClass_NONE = iota
+	DevClass_SERIAL
+	DevClass_TCP
+	DevClass_UDP
+	DevClass_BT
+)
+
+// DevDescription is the parsed form of a device string.
+type DevDescription struct {
+	Klass  int
+	Name   string
+	Param  int
+	Name1  string
+	Param1 int
+	Listen bool
+}
+
+func splithost(uhost string) (string, int) {
+	port := -1
+	host := ""
+	if uhost != "" {
+		if h, p, err := net.SplitHostPort(uhost); err != nil {
+			host = uhost
+		} else {
+			host = h
+			port, _ = strconv.Atoi(p)
+		}
+	}
+	return host, port
+}
+
+// ParseDevice classifies a device string, one of
+//
+//	/dev/ttyACM0[@baud]
+//	tcp://host:port
+//	tcp://[host]:port?listen  (accept a connection)
+//	udp://host:port           (remote host)
+//	udp://:port               (listen on port, reply to the sender)
+//	udp://host:port?bind=port (bind local port, remote host)
+//	udp://:port/host:port     (bind local port, remote host)
+//	xx:xx:xx:xx:xx:xx (bluetooth RFCOMM, Linux only)
+func ParseDevice(devstr string) DevDescription {
+	dd := DevDescription{Name: "", Klass: DevClass_NONE}
+	if devstr == "" {
+		return dd
+	}
+
+	if len(devstr) == 17 && (devstr)[2] == ':' && (devstr)[8] == ':' && (devstr)[14] == ':' {
+		dd.Name = devstr
+		dd.Klass = DevClass_BT
+		dd.Param = 1 // RFCOMM channel
+	} else {
+		u, err := url.Parse(devstr)
+		if err == nil {
+			if u.Scheme == "tcp" {
+				dd.Klass = DevClass_TCP
+			} else if u.Scheme == "udp" {
+				dd.Klass = DevClass_UDP
+			}
+
+			if u.Scheme == "" {
+				ss := strings.Split(u.Path, "@")
+				dd.Klass = DevClass_SERIAL
+				dd.Name = ss[0]
+				if len(ss) > 1 {
+					dd.Param, _ = strconv.Atoi(ss[1])
+				} else {
+					dd.Param = 115200
+				}
+			} else {
+				if u.RawQuery != "" {
+					m, err := url.ParseQuery(u.RawQuery)
+					if err == nil {
+						if _, ok := m["listen"]; ok {
+							dd.Listen = true
+							dd.Name, dd.Param = splithost(u.Host)
+						} else {
+							if p, ok := m["bind"]; ok {
+								dd.Param, _ = strconv.Atoi(p[0])
+							}
+							dd.Name1, dd.Param1 = splithost(u.Host)
+						}
+					}
+				} else {
+					if u.Path != "" {
+						parts := strings.Split(u.Path, ":")
+						if len(parts) == 2 {
+							dd.Name1 = parts[0][1:]
+							dd.Param1, _ = strconv.Atoi(parts[1])
+						}
+					}
+					dd.Name, dd.Param = splithost(u.Host)
+				}
+			}
+		}
+	}
+	return dd
+}
+
+// OpenDevice opens the raw transport for dd.
+func OpenDevice(dd DevDescription) (Transport, error) {
+	return OpenDeviceContext(context.Background(), dd)
+}
+
+// OpenDeviceContext is OpenDevice, giving up on a TCP connect or a wait for
+// a TCP peer to connect if ctx is cancelled.
+func OpenDeviceContext(ctx context.Context, dd DevDescription) (Transport, error) {
+	var p Transport
+	var err error
+	switch dd.Klass {
+	case DevClass_SERIAL:
+		pt, perr := serial.Open(dd.Name, serial.WithBaudrate(dd.Param), serial.WithReadTimeout(1))
+		err = perr
+		if err == nil {
+			pt.SetFirstByteReadTimeout(100)
+			pt.ResetInputBuffer()
+			pt.ResetOutputBuffer()
+			p = Transport(pt)
+		}
+	case DevClass_TCP:
+		p, err = open_tcp(ctx, dd)
+	case DevClass_UDP:
+		p, err = open_udp(dd)
+	case DevClass_BT:
+		p, err = open_bt(sysBTSocket{}, dd.Name, dd.Param)
+	default:
+		err = errors.New("unavailable device")
+	}
+	return p, err
+}
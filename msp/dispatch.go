@@ -0,0 +1,98 @@
+package msp
+
+import (
+	"sync/atomic"
+)
+
+// AnyCmd subscribes to every command.
+const AnyCmd uint16 = 0xffff
+
+// Subscription is a registered consumer of received frames. Delivery never
+// blocks the reader: a frame that finds the consumer busy is dropped and
+// counted.
+type Subscription struct {
+	dropped uint64 // first, for 64 bit alignment
+	c       *Client
+	cmd     uint16
+	ch      chan Frame
+	fn      func(Frame)
+	quit    chan struct{}
+}
+
+// Subscribe sends frames for cmd (or AnyCmd) to ch, which should be buffered.
+// ch is never closed by the client.
+func (p *Client) Subscribe(cmd uint16, ch chan Frame) *Subscription {
+	s := &Subscription{c: p, cmd: cmd, ch: ch, quit: make(chan struct{})}
+	p.mu.Lock()
+	p.subs = append(p.subs, s)
+	p.mu.Unlock()
+	return s
+}
+
+// Handle calls fn, from its own goroutine, for each frame for cmd (or
+// AnyCmd) until the subscription is cancelled or the client shuts down.
+func (p *Client) Handle(cmd uint16, fn func(Frame)) *Subscription {
+	s := p.Subscribe(cmd, make(chan Frame, 16))
+	go func() {
+		for {
+			select {
+			case f := <-s.ch:
+				fn(f)
+			case <-s.quit:
+				return
+			case <-p.closed:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// Tap calls fn for each frame for cmd (or AnyCmd) from the reader goroutine,
+// so no frame is ever dropped; reading stops until fn returns, so it must not
+// block for long.
+func (p *Client) Tap(cmd uint16, fn func(Frame)) *Subscription {
+	s := &Subscription{c: p, cmd: cmd, fn: fn, quit: make(chan struct{})}
+	p.mu.Lock()
+	p.subs = append(p.subs, s)
+	p.mu.Unlock()
+	return s
+}
+
+// Cancel removes the subscription; it may be called more than once.
+func (s *Subscription) Cancel() {
+	p := s.c
+	p.mu.Lock()
+	for j, x := range p.subs {
+		if x == s {
+			p.subs = append(p.subs[:j:j], p.subs[j+1:]...)
+			close(s.quit)
+			break
+		}
+	}
+	p.mu.Unlock()
+}
+
+// Dropped returns the number of frames lost because the consumer was busy.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+func (p *Client) publish(f Frame) {
+	p.mu.Lock()
+	subs := p.subs
+	p.mu.Unlock()
+	for _, s := range subs {
+		if s.cmd == AnyCmd || s.cmd == f.Cmd {
+			if s.fn != nil {
+				s.fn(f)
+				continue
+			}
+			select {
+			case s.ch <- f:
+			default:
+				atomic.AddUint64(&s.dropped, 1)
+			}
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package msp
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrCRC             = errors.New("msp: CRC error")
+	ErrFCRejected      = errors.New("msp: command rejected by FC")
+	ErrTimeout         = errors.New("msp: timeout")
+	ErrTransportClosed = errors.New("msp: transport closed")
+)
+
+// TimeoutError is returned by Request when no reply was received; it
+// matches ErrTimeout.
+type TimeoutError struct {
+	Cmd      uint16
+	Attempts int
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("msp: timeout on %s after %d attempts", CmdName(e.Cmd), e.Attempts)
+}
+
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+// TransportError reports the failure of the underlying transport; it
+// matches ErrTransportClosed and unwraps to the I/O error.
+type TransportError struct {
+	Err error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("msp: transport closed: %v", e.Err)
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+func (e *TransportError) Is(target error) bool {
+	return target == ErrTransportClosed
+}
@@ -0,0 +1,105 @@
+package msp
+
+import (
+	"sort"
+	"time"
+)
+
+// rtt_window is the number of recent round trips kept for the percentile.
+const rtt_window = 256
+
+// CmdStats summarises the requests made for one command.
+type CmdStats struct {
+	Cmd      uint16
+	Replies  uint64
+	Timeouts uint64 // requests abandoned after all retries
+	Retries  uint64 // resends after a timeout or corrupt reply
+	Min      time.Duration
+	Avg      time.Duration
+	P95      time.Duration // over the last rtt_window replies
+	Max      time.Duration
+}
+
+type rtt_track struct {
+	n        uint64
+	timeouts uint64
+	retries  uint64
+	sum      time.Duration
+	min      time.Duration
+	max      time.Duration
+	ring     [rtt_window]time.Duration
+}
+
+func (t *rtt_track) add(d time.Duration) {
+	t.ring[t.n%rtt_window] = d
+	t.n++
+	t.sum += d
+	if t.min == 0 || d < t.min {
+		t.min = d
+	}
+	if d > t.max {
+		t.max = d
+	}
+}
+
+func (t *rtt_track) stats(cmd uint16) CmdStats {
+	cs := CmdStats{Cmd: cmd, Replies: t.n, Timeouts: t.timeouts, Retries: t.retries, Min: t.min, Max: t.max}
+	if t.n > 0 {
+		cs.Avg = t.sum / time.Duration(t.n)
+		n := t.n
+		if n > rtt_window {
+			n = rtt_window
+		}
+		w := make([]time.Duration, n)
+		copy(w, t.ring[:n])
+		sort.Slice(w, func(i, j int) bool { return w[i] < w[j] })
+		cs.P95 = w[(len(w)*95+99)/100-1]
+	}
+	return cs
+}
+
+// record notes the outcome of a Request; d is the round trip of the attempt
+// that was answered, or 0 for a timeout.
+func (p *Client) record(cmd uint16, d time.Duration, retries int, timeout bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range []uint16{cmd, AnyCmd} {
+		t := p.rtt[c]
+		if t == nil {
+			t = &rtt_track{}
+			p.rtt[c] = t
+		}
+		t.retries += uint64(retries)
+		if timeout {
+			t.timeouts++
+		} else {
+			t.add(d)
+		}
+	}
+}
+
+// Latency returns the round trip statistics of Requests for cmd, or for all
+// commands together if cmd is AnyCmd.
+func (p *Client) Latency(cmd uint16) CmdStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t := p.rtt[cmd]; t != nil {
+		return t.stats(cmd)
+	}
+	return CmdStats{Cmd: cmd}
+}
+
+// Latencies returns the round trip statistics of every command requested,
+// in command order.
+func (p *Client) Latencies() []CmdStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var cl []CmdStats
+	for c, t := range p.rtt {
+		if c != AnyCmd {
+			cl = append(cl, t.stats(c))
+		}
+	}
+	sort.Slice(cl, func(i, j int) bool { return cl[i].Cmd < cl[j].Cmd })
+	return cl
+}
@@ -0,0 +1,355 @@
+// Package msp implements the MultiWii Serial Protocol (v1 and v2) as used by
+// inav, betaflight and friends, over serial, TCP and UDP transports.
+package msp
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	Msp_API_VERSION         uint16 = 1
+	Msp_FC_VARIANT          uint16 = 2
+	Msp_FC_VERSION          uint16 = 3
+	Msp_BOARD_INFO          uint16 = 4
+	Msp_BUILD_INFO          uint16 = 5
+	Msp_NAME                uint16 = 10
+	Msp_SET_NAME            uint16 = 11
+	Msp_WP_GETINFO          uint16 = 20
+	Msp_REBOOT              uint16 = 68
+	Msp_DATAFLASH_READ      uint16 = 71
+	Msp_IDENT               uint16 = 100
+	Msp_RAW_GPS             uint16 = 106
+	Msp_ATTITUDE            uint16 = 108
+	Msp_ANALOG              uint16 = 110
+	Msp_BOXNAMES            uint16 = 116
+	Msp_WP                  uint16 = 118
+	Msp_STATUS_EX           uint16 = 150
+	Msp_SET_RAW_RC          uint16 = 200
+	Msp_SET_WP              uint16 = 209
+	Msp_SELECT_SETTING      uint16 = 210
+	Msp_EEPROM_WRITE        uint16 = 250
+	Msp_DEBUG               uint16 = 253
+	Msp_V2_FRAME            uint16 = 255
+	Msp_COMMON_SETTING      uint16 = 0x1003
+	Msp_COMMON_SET_SETTING  uint16 = 0x1004
+	Msp_COMMON_SETTING_INFO uint16 = 0x1007
+	Msp_COMMON_PG_LIST      uint16 = 0x1008
+	Msp_ANALOG2             uint16 = 0x2002
+	Msp_INAV_STATUS         uint16 = 0x2000
+	Msp_MISC2               uint16 = 0x203a
+	Msp_SELECT_BATTERY      uint16 = 0x2018
+	Msp_SELECT_MIXER        uint16 = 0x2080
+)
+
+const (
+	// Flag_DONT_REPLY asks the FC not to acknowledge a (set) command
+	Flag_DONT_REPLY uint8 = 1
+)
+
+// JUMBO_LEN in the MSPv1 length byte marks a jumbo frame, where the real
+// length follows the command as a 16 bit value.
+const JUMBO_LEN = 255
+
+// Frame is a single decoded MSP message.
+type Frame struct {
+	Len   uint16
+	Cmd   uint16
+	Err   error // nil, ErrFCRejected, ErrCRC or a *TransportError
+	Data  []byte
+	Dirn  byte // '<' request, '>' reply, '!' error reply
+	V2    bool
+	Flags uint8  // MSPv2 flags
+	Raw   []byte // as received, if requested from the Parser
+}
+
+// Transport is the byte stream underlying a Client.
+type Transport interface {
+	Read(buf []byte) (int, error)
+	Write(buf []byte) (int, error)
+	Close() error
+}
+
+type Client struct {
+	txbytes uint64 // first, for 64 bit alignment of the atomics
+	maxpay  int64
+	Transport
+	v2     bool
+	stream bool
+	// Timeout is the time Request waits for each attempt
+	Timeout time.Duration
+	// Retries is the number of times Request resends an unanswered command
+	Retries int
+
+	mu      sync.Mutex
+	pending map[uint16][]chan Frame
+	rtt     map[uint16]*rtt_track
+	subs    []*Subscription
+	api     APIVersion
+	parser  *Parser
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	once   sync.Once
+	reason error
+	closed chan struct{}
+	err    error
+}
+
+func Crc8DvbS2(crc byte, a byte) byte {
+	crc ^= a
+	for i := 0; i < 8; i++ {
+		if (crc & 0x80) != 0 {
+			crc = (crc << 1) ^ 0xd5
+		} else {
+			crc = crc << 1
+		}
+	}
+	return crc
+}
+
+// max_frame is the largest possible frame, a MSPv2 frame of 65535 bytes; a
+// datagram transport must read each datagram whole.
+const max_frame = 9 + 0xffff
+
+func (p *Client) reader() {
+	inp := make([]byte, max_frame)
+	var err error
+	req := 1
+	for err == nil {
+		if !p.stream || req > len(inp) {
+			req = len(inp)
+		}
+		p.parser.MaxPayload = int(atomic.LoadInt64(&p.maxpay))
+		var nb int
+		nb, err = p.Read(inp[:req])
+		if err == nil {
+			if nb == 0 {
+				time.Sleep(100 * time.Microsecond)
+			} else {
+				for i := 0; i < nb; i++ {
+					for _, f := range p.parser.Parse(inp[i]) {
+						if f.Dirn != '<' {
+							p.deliver(f)
+						}
+					}
+				}
+				req = p.parser.Need()
+			}
+		}
+	}
+	p.stop(&TransportError{Err: err})
+	p.err = p.reason
+	close(p.closed)
+}
+
+// stop records the first reason for shutting down and cancels the client
+// context, which closes the transport and so ends the reader.
+func (p *Client) stop(reason error) {
+	p.once.Do(func() {
+		p.reason = reason
+		p.cancel()
+	})
+}
+
+func (p *Client) watch() {
+	<-p.ctx.Done()
+	p.stop(p.ctx.Err())
+	p.Transport.Close()
+}
+
+// Done is closed when the client has shut down, after which Err returns the
+// reason: a *TransportError, ErrTransportClosed after Close, or the context
+// error.
+func (p *Client) Done() <-chan struct{} {
+	return p.closed
+}
+
+func (p *Client) Err() error {
+	select {
+	case <-p.closed:
+		return p.err
+	default:
+		return nil
+	}
+}
+
+// EncodeV2 returns a MSPv2 request frame for cmd.
+func EncodeV2(cmd uint16, payload []byte) []byte {
+	return encode_v2('<', 0, cmd, payload)
+}
+
+// EncodeV1 returns a MSPv1 request frame for cmd.
+func EncodeV1(cmd uint16, payload []byte) []byte {
+	return encode_v1('<', 0, cmd, payload)
+}
+
+// Encode returns the wire form of f, in its own version and direction.
+func (f Frame) Encode() []byte {
+	dirn := f.Dirn
+	if dirn == 0 {
+		dirn = '<'
+	}
+	if f.V2 {
+		return encode_v2(dirn, f.Flags, f.Cmd, f.Data)
+	}
+	return encode_v1(dirn, f.Flags, f.Cmd, f.Data)
+}
+
+func encode_v2(dirn byte, flags uint8, cmd uint16, payload []byte) []byte {
+	var paylen = int(0)
+	if len(payload) > 0 {
+		paylen = len(payload)
+	}
+	buf := make([]byte, 9+paylen)
+	buf[0] = '$'
+	buf[1] = 'X'
+	buf[2] = dirn
+	buf[3] = flags
+	binary.LittleEndian.PutUint16(buf[4:6], uint16(cmd))
+	binary.LittleEndian.PutUint16(buf[6:8], uint16(paylen))
+	if paylen > 0 {
+		copy(buf[8:], payload)
+	}
+	crc := byte(0)
+	for _, b := range buf[3 : paylen+8] {
+		crc = Crc8DvbS2(crc, b)
+	}
+	buf[8+paylen] = crc
+	return buf
+}
+
+// v2_native returns the MSPv2 frame body (flags to CRC) as tunnelled in a
+// MSPv1 frame.
+func v2_native(flags uint8, cmd uint16, payload []byte) []byte {
+	buf := make([]byte, 6+len(payload))
+	buf[0] = flags
+	binary.LittleEndian.PutUint16(buf[1:3], cmd)
+	binary.LittleEndian.PutUint16(buf[3:5], uint16(len(payload)))
+	copy(buf[5:], payload)
+	crc := byte(0)
+	for _, b := range buf[:5+len(payload)] {
+		crc = Crc8DvbS2(crc, b)
+	}
+	buf[5+len(payload)] = crc
+	return buf
+}
+
+// encode_v1 tunnels commands that do not fit in a byte, or that need flags,
+// as MSPv2 over MSPv1.
+func encode_v1(dirn byte, flags uint8, cmd uint16, payload []byte) []byte {
+	if cmd >= Msp_V2_FRAME || flags != 0 {
+		payload = v2_native(flags, cmd, payload)
+		cmd = Msp_V2_FRAME
+	}
+	paylen := len(payload)
+	hlen := 5
+	if paylen >= JUMBO_LEN {
+		hlen = 7
+	}
+	buf := make([]byte, hlen+paylen+1)
+	buf[0] = '$'
+	buf[1] = 'M'
+	buf[2] = dirn
+	buf[4] = byte(cmd)
+	if paylen >= JUMBO_LEN {
+		buf[3] = JUMBO_LEN
+		binary.LittleEndian.PutUint16(buf[5:7], uint16(paylen))
+	} else {
+		buf[3] = byte(paylen)
+	}
+	if paylen > 0 {
+		copy(buf[hlen:], payload)
+	}
+	crc := byte(0)
+	for _, b := range buf[3 : hlen+paylen] {
+		crc ^= b
+	}
+	buf[hlen+paylen] = crc
+	return buf
+}
+
+// SetMaxPayload sets the largest payload the client accepts; longer frames
+// are discarded and counted as Oversize. 0 means DefaultMaxPayload.
+func (p *Client) SetMaxPayload(n int) {
+	atomic.StoreInt64(&p.maxpay, int64(n))
+}
+
+// Stats returns the link statistics of the client's parser, plus the bytes
+// the client has sent.
+func (p *Client) Stats() Stats {
+	st := p.parser.Stats()
+	st.TxBytes = atomic.LoadUint64(&p.txbytes)
+	return st
+}
+
+// Close shuts the client down and waits for the reader to exit. It may be
+// called more than once.
+func (p *Client) Close() error {
+	p.stop(ErrTransportClosed)
+	<-p.closed
+	return nil
+}
+
+// Send writes cmd with an optional payload; any reply goes to subscribers.
+func (p *Client) Send(cmd uint16, payload []byte) error {
+	return p.SendFlags(cmd, 0, payload)
+}
+
+// SendFlags is Send with MSPv2 flags, e.g. Flag_DONT_REPLY for high rate
+// fire-and-forget commands such as MSP_SET_RAW_RC.
+func (p *Client) SendFlags(cmd uint16, flags uint8, payload []byte) error {
+	var rb []byte
+	p.mu.Lock()
+	v2 := p.v2
+	p.mu.Unlock()
+	if v2 {
+		rb = encode_v2('<', flags, cmd, payload)
+	} else {
+		rb = encode_v1('<', flags, cmd, payload)
+	}
+	n, err := p.Write(rb)
+	atomic.AddUint64(&p.txbytes, uint64(n))
+	return err
+}
+
+func (p *Client) MSPCommand(cmd uint16) {
+	p.Send(cmd, nil)
+}
+
+// Reboot asks the FC to reboot; the link will normally drop.
+func (p *Client) Reboot() error {
+	return p.Send(Msp_REBOOT, nil)
+}
+
+// NewClient opens the device described by dname (see ParseDevice) and
+// starts a reader that passes received frames to Request and to subscribers
+// (see Subscribe). The client runs until ctx is cancelled, Close is called
+// or the transport fails; see Done and Err.
+func NewClient(ctx context.Context, dname string, v2_ bool) (*Client, error) {
+	dd := ParseDevice(dname)
+	p, err := OpenDeviceContext(ctx, dd)
+	if err != nil {
+		return nil, err
+	}
+	return new_client(ctx, p, v2_, dd.Klass != DevClass_UDP), nil
+}
+
+// new_client runs a client over an open transport; stream is false for a
+// datagram transport.
+func new_client(ctx context.Context, t Transport, v2_ bool, stream_ bool) *Client {
+	m := &Client{Transport: t, v2: v2_, stream: stream_}
+	m.Timeout = DefaultTimeout
+	m.Retries = DefaultRetries
+	m.pending = make(map[uint16][]chan Frame)
+	m.rtt = make(map[uint16]*rtt_track)
+	m.closed = make(chan struct{})
+	m.parser = &Parser{}
+	m.ctx, m.cancel = context.WithCancel(ctx)
+	go m.watch()
+	go m.reader()
+	return m
+}
@@ -0,0 +1,48 @@
+package msp
+
+import (
+	"fmt"
+)
+
+var cmdnames = map[uint16]string{
+	Msp_API_VERSION:         "MSP_API_VERSION",
+	Msp_FC_VARIANT:          "MSP_FC_VARIANT",
+	Msp_FC_VERSION:          "MSP_FC_VERSION",
+	Msp_BOARD_INFO:          "MSP_BOARD_INFO",
+	Msp_BUILD_INFO:          "MSP_BUILD_INFO",
+	Msp_NAME:                "MSP_NAME",
+	Msp_SET_NAME:            "MSP_SET_NAME",
+	Msp_WP_GETINFO:          "MSP_WP_GETINFO",
+	Msp_REBOOT:              "MSP_REBOOT",
+	Msp_DATAFLASH_READ:      "MSP_DATAFLASH_READ",
+	Msp_IDENT:               "MSP_IDENT",
+	Msp_RAW_GPS:             "MSP_RAW_GPS",
+	Msp_ATTITUDE:            "MSP_ATTITUDE",
+	Msp_ANALOG:              "MSP_ANALOG",
+	Msp_BOXNAMES:            "MSP_BOXNAMES",
+	Msp_WP:                  "MSP_WP",
+	Msp_STATUS_EX:           "MSP_STATUS_EX",
+	Msp_SET_RAW_RC:          "MSP_SET_RAW_RC",
+	Msp_SET_WP:              "MSP_SET_WP",
+	Msp_SELECT_SETTING:      "MSP_SELECT_SETTING",
+	Msp_EEPROM_WRITE:        "MSP_EEPROM_WRITE",
+	Msp_DEBUG:               "MSP_DEBUG",
+	Msp_V2_FRAME:            "MSP_V2_FRAME",
+	Msp_COMMON_SETTING:      "MSP2_COMMON_SETTING",
+	Msp_COMMON_SET_SETTING:  "MSP2_COMMON_SET_SETTING",
+	Msp_COMMON_SETTING_INFO: "MSP2_COMMON_SETTING_INFO",
+	Msp_COMMON_PG_LIST:      "MSP2_COMMON_PG_LIST",
+	Msp_ANALOG2:             "MSP2_INAV_ANALOG",
+	Msp_INAV_STATUS:         "MSP2_INAV_STATUS",
+	Msp_MISC2:               "MSP2_INAV_MISC2",
+	Msp_SELECT_BATTERY:      "MSP2_INAV_SELECT_BATTERY_PROFILE",
+	Msp_SELECT_MIXER:        "MSP2_INAV_SELECT_MIXER_PROFILE",
+}
+
+// CmdName returns the protocol name of cmd, or its number if unknown.
+func CmdName(cmd uint16) string {
+	if s, ok := cmdnames[cmd]; ok {
+		return s
+	}
+	return fmt.Sprintf("MSP_%d", cmd)
+}
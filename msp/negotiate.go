@@ -0,0 +1,67 @@
+package msp
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrNoProtocol = errors.New("msp: no response to MSPv2 or MSPv1")
+
+// Negotiate probes the FC with MSP_API_VERSION, first as MSPv2 and then as
+// MSPv1, and switches the client to whichever protocol it answered in. An FC
+// that rejects the MSPv2 probe is also tried with MSPv1.
+func (p *Client) Negotiate(ctx context.Context) error {
+	timeout := p.Timeout
+	if timeout > 250*time.Millisecond {
+		timeout = 250 * time.Millisecond
+	}
+	p.mu.Lock()
+	v2_ := p.v2
+	p.mu.Unlock()
+
+	for _, v2 := range []bool{true, false} {
+		p.mu.Lock()
+		p.v2 = v2
+		p.mu.Unlock()
+		f, err := p.request(ctx, Msp_API_VERSION, nil, timeout, 1)
+		if err == nil {
+			var m APIVersion
+			if err = m.Unmarshal(f.Data); err != nil {
+				return err
+			}
+			p.mu.Lock()
+			p.v2 = f.V2
+			p.api = m
+			p.mu.Unlock()
+			return nil
+		}
+		if !errors.Is(err, ErrTimeout) && !errors.Is(err, ErrFCRejected) {
+			p.mu.Lock()
+			p.v2 = v2_
+			p.mu.Unlock()
+			return err
+		}
+	}
+	p.mu.Lock()
+	p.v2 = v2_
+	p.mu.Unlock()
+	return ErrNoProtocol
+}
+
+// Version returns the MSP protocol version in use, 1 or 2.
+func (p *Client) Version() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.v2 {
+		return 2
+	}
+	return 1
+}
+
+// APIVersion returns the FC's MSP API version found by Negotiate.
+func (p *Client) APIVersion() APIVersion {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.api
+}
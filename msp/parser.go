@@ -0,0 +1,310 @@
+package msp
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+)
+
+const (
+	state_INIT = iota
+	state_M
+	state_DIRN
+	state_LEN
+	state_CMD
+	state_JUMBO1
+	state_JUMBO2
+	state_DATA
+	state_CRC
+
+	state_X_HEADER2
+	state_X_FLAGS
+	state_X_ID1
+	state_X_ID2
+	state_X_LEN1
+	state_X_LEN2
+	state_X_DATA
+	state_X_CHECKSUM
+)
+
+// DefaultMaxPayload is the largest payload accepted by a Parser unless its
+// MaxPayload is set; it covers the largest real reply, a 4096 byte
+// MSP_DATAFLASH_READ chunk with its 7 byte header.
+const DefaultMaxPayload = 4096 + 7
+
+// Stats counts what a Parser has seen.
+type Stats struct {
+	Frames    uint64 // good frames
+	CRCErrors uint64
+	Discarded uint64 // bytes not part of any good frame
+	Oversize  uint64 // frames claiming more than MaxPayload bytes
+	RxBytes   uint64 // bytes parsed
+	TxBytes   uint64 // bytes sent, counted by Client
+}
+
+// Parser is the MSP v1/v2 frame decoder. It accepts frames in all three
+// directions; Client discards requests, the bridge needs them.
+//
+// When a candidate frame proves bogus (bad header, oversize or CRC error),
+// parsing restarts from the byte after its '$', so a real frame hidden
+// behind a false start is not lost.
+type Parser struct {
+	stats Stats // first, for 64 bit alignment of the atomics
+	// KeepRaw sets Frame.Raw to the bytes of each frame as received
+	KeepRaw bool
+	// MaxPayload limits the length field, 0 means DefaultMaxPayload
+	MaxPayload int
+	n          int
+	crc        byte
+	count      uint16
+	sc         Frame
+	raw        []byte
+	pend       []byte
+}
+
+// Need returns the number of bytes that may be read without overrunning the
+// current frame.
+func (p *Parser) Need() int {
+	if p.n == state_DATA || p.n == state_X_DATA {
+		return int(p.sc.Len - p.count)
+	}
+	return 1
+}
+
+// Stats may be called concurrently with Parse.
+func (p *Parser) Stats() Stats {
+	return Stats{
+		Frames:    atomic.LoadUint64(&p.stats.Frames),
+		CRCErrors: atomic.LoadUint64(&p.stats.CRCErrors),
+		Discarded: atomic.LoadUint64(&p.stats.Discarded),
+		Oversize:  atomic.LoadUint64(&p.stats.Oversize),
+		RxBytes:   atomic.LoadUint64(&p.stats.RxBytes),
+	}
+}
+
+// resync abandons the current candidate and queues its bytes after the '$'
+// to be parsed again.
+func (p *Parser) resync() {
+	atomic.AddUint64(&p.stats.Discarded, 1)
+	if len(p.raw) > 1 {
+		p.pend = append(append([]byte{}, p.raw[1:]...), p.pend...)
+	}
+	p.raw = p.raw[:0]
+	p.n = state_INIT
+}
+
+func (p *Parser) oversize() bool {
+	max := p.MaxPayload
+	if max == 0 {
+		max = DefaultMaxPayload
+	}
+	if int(p.sc.Len) > max {
+		atomic.AddUint64(&p.stats.Oversize, 1)
+		p.resync()
+		return true
+	}
+	return false
+}
+
+func set_dirn(sc *Frame, c byte) bool {
+	switch c {
+	case '!':
+		sc.Err = ErrFCRejected
+	case '>', '<':
+		sc.Err = nil
+	default:
+		return false
+	}
+	sc.Dirn = c
+	return true
+}
+
+// unwrap replaces a MSPv2 frame tunnelled in MSPv1 by its content.
+func unwrap_v2(sc *Frame) {
+	d := sc.Data
+	if len(d) < 6 {
+		sc.Err = ErrCRC
+		return
+	}
+	plen := int(binary.LittleEndian.Uint16(d[3:5]))
+	if len(d) != plen+6 {
+		sc.Err = ErrCRC
+		return
+	}
+	crc := byte(0)
+	for _, b := range d[:5+plen] {
+		crc = Crc8DvbS2(crc, b)
+	}
+	if crc != d[5+plen] {
+		sc.Err = ErrCRC
+		return
+	}
+	sc.Flags = d[0]
+	sc.Cmd = binary.LittleEndian.Uint16(d[1:3])
+	sc.Len = uint16(plen)
+	sc.Data = d[5 : 5+plen]
+}
+
+func (p *Parser) done() (Frame, bool) {
+	p.n = state_INIT
+	if !p.sc.V2 && p.sc.Cmd == Msp_V2_FRAME && p.sc.Err != ErrCRC {
+		unwrap_v2(&p.sc)
+	}
+	if p.KeepRaw {
+		p.sc.Raw = append([]byte{}, p.raw...)
+	}
+	if p.sc.Err == ErrCRC {
+		atomic.AddUint64(&p.stats.CRCErrors, 1)
+		p.resync()
+	} else {
+		atomic.AddUint64(&p.stats.Frames, 1)
+		p.raw = p.raw[:0]
+	}
+	return p.sc, true
+}
+
+func (p *Parser) start_v1_data() {
+	if p.sc.Len == 0 {
+		p.n = state_CRC
+	} else {
+		p.sc.Data = make([]byte, p.sc.Len)
+		p.n = state_DATA
+		p.count = 0
+	}
+}
+
+// Parse consumes one byte, returning any frames completed by it (more than
+// one only after a resynchronisation).
+func (p *Parser) Parse(c byte) []Frame {
+	var out []Frame
+	atomic.AddUint64(&p.stats.RxBytes, 1)
+	p.pend = append(p.pend, c)
+	for len(p.pend) > 0 {
+		c = p.pend[0]
+		p.pend = p.pend[1:]
+		if f, ok := p.step(c); ok {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func (p *Parser) step(c byte) (Frame, bool) {
+	if p.n == state_INIT {
+		if c != '$' {
+			atomic.AddUint64(&p.stats.Discarded, 1)
+			return Frame{}, false
+		}
+		p.raw = p.raw[:0]
+	}
+	p.raw = append(p.raw, c)
+	switch p.n {
+	case state_INIT:
+		p.n = state_M
+		p.sc = Frame{}
+	case state_M:
+		if c == 'M' {
+			p.n = state_DIRN
+		} else if c == 'X' {
+			p.n = state_X_HEADER2
+			p.sc.V2 = true
+		} else {
+			p.resync()
+		}
+	case state_DIRN:
+		if set_dirn(&p.sc, c) {
+			p.n = state_LEN
+		} else {
+			p.resync()
+		}
+
+	case state_X_HEADER2:
+		if set_dirn(&p.sc, c) {
+			p.n = state_X_FLAGS
+		} else {
+			p.resync()
+		}
+
+	case state_X_FLAGS:
+		p.crc = Crc8DvbS2(0, c)
+		p.sc.Flags = c
+		p.n = state_X_ID1
+
+	case state_X_ID1:
+		p.crc = Crc8DvbS2(p.crc, c)
+		p.sc.Cmd = uint16(c)
+		p.n = state_X_ID2
+
+	case state_X_ID2:
+		p.crc = Crc8DvbS2(p.crc, c)
+		p.sc.Cmd |= (uint16(c) << 8)
+		p.n = state_X_LEN1
+
+	case state_X_LEN1:
+		p.crc = Crc8DvbS2(p.crc, c)
+		p.sc.Len = uint16(c)
+		p.n = state_X_LEN2
+
+	case state_X_LEN2:
+		p.crc = Crc8DvbS2(p.crc, c)
+		p.sc.Len |= (uint16(c) << 8)
+		if p.oversize() {
+			break
+		}
+		if p.sc.Len > 0 {
+			p.n = state_X_DATA
+			p.count = 0
+			p.sc.Data = make([]byte, p.sc.Len)
+		} else {
+			p.n = state_X_CHECKSUM
+		}
+	case state_X_DATA:
+		p.crc = Crc8DvbS2(p.crc, c)
+		p.sc.Data[p.count] = c
+		p.count++
+		if p.count == p.sc.Len {
+			p.n = state_X_CHECKSUM
+		}
+
+	case state_X_CHECKSUM:
+		if p.crc != c {
+			p.sc.Err = ErrCRC
+		}
+		return p.done()
+
+	case state_LEN:
+		p.sc.Len = uint16(c)
+		p.crc = c
+		p.n = state_CMD
+	case state_CMD:
+		p.sc.Cmd = uint16(c)
+		p.crc ^= c
+		if p.sc.Len == JUMBO_LEN {
+			p.n = state_JUMBO1
+		} else if !p.oversize() {
+			p.start_v1_data()
+		}
+	case state_JUMBO1:
+		p.sc.Len = uint16(c)
+		p.crc ^= c
+		p.n = state_JUMBO2
+	case state_JUMBO2:
+		p.sc.Len |= (uint16(c) << 8)
+		p.crc ^= c
+		if !p.oversize() {
+			p.start_v1_data()
+		}
+	case state_DATA:
+		p.sc.Data[p.count] = c
+		p.crc ^= c
+		p.count++
+		if p.count == p.sc.Len {
+			p.n = state_CRC
+		}
+	case state_CRC:
+		if p.crc != c {
+			p.sc.Err = ErrCRC
+		}
+		return p.done()
+	}
+	return Frame{}, false
+}
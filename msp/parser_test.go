@@ -0,0 +1,208 @@
+package msp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func test_payload(n int) []byte {
+	b := make([]byte, n)
+	for j := range b {
+		b[j] = byte('a' + j%26)
+	}
+	return b
+}
+
+// parse_all feeds b to p in chunks of the given size, as a reader would.
+func parse_all(p *Parser, b []byte, chunk int) []Frame {
+	var out []Frame
+	for len(b) > 0 {
+		n := chunk
+		if n > len(b) {
+			n = len(b)
+		}
+		for _, c := range b[:n] {
+			out = append(out, p.Parse(c)...)
+		}
+		b = b[n:]
+	}
+	return out
+}
+
+func TestRoundTrip(t *testing.T) {
+	encoders := []struct {
+		name string
+		cmd  uint16
+		v2   bool
+		enc  func(uint16, []byte) []byte
+	}{
+		{"v1", Msp_ATTITUDE, false, EncodeV1},
+		{"v1 tunnel", Msp_COMMON_SETTING, false, EncodeV1},
+		{"v2", Msp_COMMON_SETTING, true, EncodeV2},
+	}
+	for _, e := range encoders {
+		for _, n := range []int{0, 1, 254, 255, 256, 1000, 4000} {
+			pl := test_payload(n)
+			var p Parser
+			fl := parse_all(&p, e.enc(e.cmd, pl), 1)
+			if len(fl) != 1 {
+				t.Fatalf("%s len %d: %d frames", e.name, n, len(fl))
+			}
+			f := fl[0]
+			if f.Err != nil || f.Cmd != e.cmd || f.V2 != e.v2 || f.Dirn != '<' ||
+				int(f.Len) != n || !bytes.Equal(f.Data, pl) {
+				t.Errorf("%s len %d: got cmd %d v2 %v len %d err %v", e.name, n, f.Cmd, f.V2, f.Len, f.Err)
+			}
+		}
+	}
+}
+
+func TestJumboSplit(t *testing.T) {
+	pl := test_payload(1000)
+	b := EncodeV1(Msp_ATTITUDE, pl)
+	if b[3] != JUMBO_LEN {
+		t.Fatalf("not a jumbo frame: len byte %d", b[3])
+	}
+	// split within the header, the jumbo length and the data
+	for _, chunk := range []int{1, 4, 6, 7, 100, 999} {
+		var p Parser
+		fl := parse_all(&p, b, chunk)
+		if len(fl) != 1 || fl[0].Err != nil || !bytes.Equal(fl[0].Data, pl) {
+			t.Errorf("chunk %d: %d frames", chunk, len(fl))
+		}
+	}
+}
+
+// echo_fc answers each request read from conn with a v1 reply carrying
+// reply, written in pieces of chunk bytes.
+func echo_fc(conn io.ReadWriter, reply []byte, chunk int) {
+	var p Parser
+	b := make([]byte, 1)
+	for {
+		if _, err := conn.Read(b); err != nil {
+			return
+		}
+		for _, f := range p.Parse(b[0]) {
+			rb := Frame{Cmd: f.Cmd, Dirn: '>', Data: reply}.Encode()
+			for len(rb) > 0 {
+				n := chunk
+				if n > len(rb) {
+					n = len(rb)
+				}
+				if _, err := conn.Write(rb[:n]); err != nil {
+					return
+				}
+				rb = rb[n:]
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+}
+
+func TestClientJumboSplit(t *testing.T) {
+	pl := test_payload(1000)
+	for _, chunk := range []int{1, 6, 300} {
+		c, fc := net.Pipe()
+		go echo_fc(fc, pl, chunk)
+		sp := new_client(context.Background(), c, false, true)
+		f, err := sp.Request(context.Background(), Msp_ATTITUDE, nil)
+		if err != nil || !bytes.Equal(f.Data, pl) {
+			t.Errorf("chunk %d: len %d err %v", chunk, len(f.Data), err)
+		}
+		sp.Close()
+		fc.Close()
+	}
+}
+
+// dgram is a datagram transport: each Read returns one datagram, truncated
+// to the buffer as a UDP socket would.
+type dgram struct {
+	rx   chan []byte
+	once sync.Once
+	done chan struct{}
+}
+
+func (d *dgram) Read(buf []byte) (int, error) {
+	select {
+	case b := <-d.rx:
+		return copy(buf, b), nil
+	case <-d.done:
+		return 0, io.EOF
+	}
+}
+
+func (d *dgram) Write(buf []byte) (int, error) {
+	f := Frame{Cmd: Msp_ATTITUDE, Dirn: '>', Data: test_payload(1000)}
+	d.rx <- f.Encode()
+	return len(buf), nil
+}
+
+func (d *dgram) Close() error {
+	d.once.Do(func() { close(d.done) })
+	return nil
+}
+
+func TestClientDatagram(t *testing.T) {
+	d := &dgram{rx: make(chan []byte, 1), done: make(chan struct{})}
+	sp := new_client(context.Background(), d, false, false)
+	defer sp.Close()
+	sp.Retries = 0
+	f, err := sp.Request(context.Background(), Msp_ATTITUDE, nil)
+	if err != nil || !bytes.Equal(f.Data, test_payload(1000)) {
+		t.Errorf("len %d err %v", len(f.Data), err)
+	}
+}
+
+func TestJumboBadCRC(t *testing.T) {
+	pl := test_payload(300)
+	b := EncodeV1(Msp_ATTITUDE, pl)
+	b[len(b)-1] ^= 0xff
+	good := EncodeV1(Msp_IDENT, []byte{1, 2, 3})
+	var p Parser
+	fl := parse_all(&p, append(b, good...), 1)
+	if len(fl) != 2 {
+		t.Fatalf("%d frames", len(fl))
+	}
+	if !errors.Is(fl[0].Err, ErrCRC) {
+		t.Errorf("bad CRC: err %v", fl[0].Err)
+	}
+	if fl[1].Err != nil || fl[1].Cmd != Msp_IDENT {
+		t.Errorf("following frame: cmd %d err %v", fl[1].Cmd, fl[1].Err)
+	}
+	if st := p.Stats(); st.CRCErrors != 1 || st.Frames != 1 {
+		t.Errorf("stats %+v", st)
+	}
+}
+
+func TestClientMaxPayload(t *testing.T) {
+	for _, tc := range []struct {
+		n, max int
+		ok     bool
+	}{
+		{4096 + 7, 0, true},
+		{6000, 0, false},
+		{6000, 8192, true},
+	} {
+		c, fc := net.Pipe()
+		go echo_fc(fc, test_payload(tc.n), 1024)
+		sp := new_client(context.Background(), c, true, true)
+		sp.SetMaxPayload(tc.max)
+		sp.Timeout = 100 * time.Millisecond
+		sp.Retries = 0
+		f, err := sp.Request(context.Background(), Msp_DATAFLASH_READ, nil)
+		if ok := err == nil && int(f.Len) == tc.n; ok != tc.ok {
+			t.Errorf("len %d max %d: got len %d err %v", tc.n, tc.max, f.Len, err)
+		}
+		if !tc.ok && sp.Stats().Oversize == 0 {
+			t.Errorf("len %d max %d: not counted oversize", tc.n, tc.max)
+		}
+		sp.Close()
+		fc.Close()
+	}
+}
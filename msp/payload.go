@@ -0,0 +1,74 @@
+package msp
+
+import (
+	"encoding/binary"
+)
+
+// Payload builds a little-endian MSP message body, e.g.
+//
+//	pl := msp.NewPayload().U8(1).I32(lat).I32(lon)
+//	c.Send(msp.Msp_SET_WP, pl.Bytes())
+type Payload struct {
+	buf []byte
+}
+
+func NewPayload() *Payload {
+	return &Payload{buf: make([]byte, 0, 32)}
+}
+
+func (p *Payload) U8(v uint8) *Payload {
+	p.buf = append(p.buf, v)
+	return p
+}
+
+func (p *Payload) I8(v int8) *Payload {
+	return p.U8(uint8(v))
+}
+
+func (p *Payload) U16(v uint16) *Payload {
+	var b [2]byte
+	binary.LittleEndian.PutUint16(b[:], v)
+	p.buf = append(p.buf, b[:]...)
+	return p
+}
+
+func (p *Payload) I16(v int16) *Payload {
+	return p.U16(uint16(v))
+}
+
+func (p *Payload) U32(v uint32) *Payload {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	p.buf = append(p.buf, b[:]...)
+	return p
+}
+
+func (p *Payload) I32(v int32) *Payload {
+	return p.U32(uint32(v))
+}
+
+// String appends s without a terminator.
+func (p *Payload) String(s string) *Payload {
+	p.buf = append(p.buf, s...)
+	return p
+}
+
+// CString appends s followed by a NUL.
+func (p *Payload) CString(s string) *Payload {
+	p.buf = append(p.buf, s...)
+	p.buf = append(p.buf, 0)
+	return p
+}
+
+func (p *Payload) Data(b []byte) *Payload {
+	p.buf = append(p.buf, b...)
+	return p
+}
+
+func (p *Payload) Bytes() []byte {
+	return p.buf
+}
+
+func (p *Payload) Len() int {
+	return len(p.buf)
+}
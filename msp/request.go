@@ -0,0 +1,84 @@
+package msp
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	DefaultTimeout = 500 * time.Millisecond
+	DefaultRetries = 2
+)
+
+// deliver hands a frame to the oldest Request waiting on its command, and
+// to every subscriber.
+func (p *Client) deliver(f Frame) {
+	p.mu.Lock()
+	if q := p.pending[f.Cmd]; len(q) > 0 {
+		q[0] <- f
+		p.pending[f.Cmd] = q[1:]
+	}
+	p.mu.Unlock()
+	p.publish(f)
+}
+
+func (p *Client) wait(cmd uint16) chan Frame {
+	ch := make(chan Frame, 1)
+	p.mu.Lock()
+	p.pending[cmd] = append(p.pending[cmd], ch)
+	p.mu.Unlock()
+	return ch
+}
+
+func (p *Client) unwait(cmd uint16, ch chan Frame) {
+	p.mu.Lock()
+	q := p.pending[cmd]
+	for j, c := range q {
+		if c == ch {
+			p.pending[cmd] = append(q[:j:j], q[j+1:]...)
+			break
+		}
+	}
+	p.mu.Unlock()
+}
+
+// Request sends cmd with payload and waits for the matching reply, resending
+// up to p.Retries times if no reply arrives. The first attempt waits
+// p.Timeout, each retry twice as long as the one before.
+func (p *Client) Request(ctx context.Context, cmd uint16, payload []byte) (Frame, error) {
+	return p.request(ctx, cmd, payload, p.Timeout, p.Retries)
+}
+
+func (p *Client) request(ctx context.Context, cmd uint16, payload []byte, timeout time.Duration, retries int) (Frame, error) {
+	attempts := 0
+	for ; attempts <= retries; timeout *= 2 {
+		attempts++
+		ch := p.wait(cmd)
+		if err := p.Send(cmd, payload); err != nil {
+			p.unwait(cmd, ch)
+			return Frame{}, err
+		}
+		sent := time.Now()
+		t := time.NewTimer(timeout)
+		select {
+		case f := <-ch:
+			t.Stop()
+			if f.Err == ErrCRC {
+				continue
+			}
+			p.record(cmd, time.Since(sent), attempts-1, false)
+			return f, f.Err
+		case <-t.C:
+			p.unwait(cmd, ch)
+		case <-ctx.Done():
+			t.Stop()
+			p.unwait(cmd, ch)
+			return Frame{}, ctx.Err()
+		case <-p.closed:
+			t.Stop()
+			return Frame{}, p.Err()
+		}
+	}
+	p.record(cmd, 0, attempts-1, true)
+	return Frame{}, &TimeoutError{Cmd: cmd, Attempts: attempts}
+}
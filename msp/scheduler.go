@@ -0,0 +1,170 @@
+package msp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// PollItem is a command polled by a Scheduler at a target rate.
+type PollItem struct {
+	Cmd     uint16
+	Rate    float64 // Hz
+	Handler func(Frame)
+	due     time.Time
+	count   uint64
+	misses  int // consecutive failed requests
+}
+
+// MaxStallDelay bounds how far a failing item's next poll is put back.
+const MaxStallDelay = 8 * time.Second
+
+// Scheduler polls a set of commands, each at its own rate, with exactly one
+// request in flight. The item most overdue is always sent next, and an item
+// that has fallen a whole period behind is not made to catch up, so when the
+// link is too slow for the requested rates every item degrades in proportion
+// rather than the fastest starving the rest.
+//
+// A request that fails (after the Client's retries) is given up and the next
+// item is polled; the failing item is then polled at an exponentially
+// decreasing rate until it answers again, so a dead command cannot hog the
+// link.
+type Scheduler struct {
+	// OnError is called, if set, when a request fails; polling continues.
+	OnError func(cmd uint16, err error)
+	c       *Client
+	mu      sync.Mutex
+	items   []*PollItem
+	start   time.Time
+}
+
+func NewScheduler(c *Client) *Scheduler {
+	return &Scheduler{c: c}
+}
+
+// Add polls cmd at rate Hz, passing each reply to fn. A rate <= 0 is ignored.
+func (s *Scheduler) Add(cmd uint16, rate float64, fn func(Frame)) *PollItem {
+	if rate <= 0 {
+		return nil
+	}
+	it := &PollItem{Cmd: cmd, Rate: rate, Handler: fn}
+	s.mu.Lock()
+	s.items = append(s.items, it)
+	s.mu.Unlock()
+	return it
+}
+
+// Achieved returns the rate (Hz) at which each command has been answered
+// since Run started.
+func (s *Scheduler) Achieved() map[uint16]float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := make(map[uint16]float64)
+	secs := time.Since(s.start).Seconds()
+	for _, it := range s.items {
+		if secs > 0 {
+			m[it.Cmd] = float64(it.count) / secs
+		}
+	}
+	return m
+}
+
+// Stalled returns the number of consecutive failures of each command that
+// is currently not answering.
+func (s *Scheduler) Stalled() map[uint16]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := make(map[uint16]int)
+	for _, it := range s.items {
+		if it.misses > 0 {
+			m[it.Cmd] = it.misses
+		}
+	}
+	return m
+}
+
+func (s *Scheduler) next() *PollItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var nx *PollItem
+	for _, it := range s.items {
+		if nx == nil || it.due.Before(nx.due) {
+			nx = it
+		}
+	}
+	return nx
+}
+
+// Run polls until ctx is cancelled or the client shuts down.
+func (s *Scheduler) Run(ctx context.Context) error {
+	s.mu.Lock()
+	s.start = time.Now()
+	for _, it := range s.items {
+		it.due = s.start
+	}
+	s.mu.Unlock()
+
+	for {
+		it := s.next()
+		if it == nil {
+			return errors.New("msp: nothing to schedule")
+		}
+		if wait := time.Until(it.due); wait > 0 {
+			t := time.NewTimer(wait)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return ctx.Err()
+			case <-s.c.Done():
+				t.Stop()
+				return s.c.Err()
+			}
+		}
+
+		f, err := s.c.Request(ctx, it.Cmd, nil)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if errors.Is(err, ErrTransportClosed) || s.c.Err() != nil {
+				return err
+			}
+			s.mu.Lock()
+			it.misses++
+			s.mu.Unlock()
+			if s.OnError != nil {
+				s.OnError(it.Cmd, err)
+			}
+		} else {
+			s.mu.Lock()
+			it.count++
+			it.misses = 0
+			s.mu.Unlock()
+			if it.Handler != nil {
+				it.Handler(f)
+			}
+		}
+
+		now := time.Now()
+		s.mu.Lock()
+		period := time.Duration(float64(time.Second) / it.Rate)
+		if it.misses > 0 {
+			delay := period
+			for j := 0; j < it.misses && delay < MaxStallDelay; j++ {
+				delay *= 2
+			}
+			if delay > MaxStallDelay {
+				delay = MaxStallDelay
+			}
+			it.due = now.Add(delay)
+		} else {
+			it.due = it.due.Add(period)
+			if it.due.Before(now) {
+				it.due = now
+			}
+		}
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,432 @@
+package msp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Setting value types, as reported by MSP2_COMMON_SETTING_INFO
+const (
+	Setting_UINT8  = 0
+	Setting_INT8   = 1
+	Setting_UINT16 = 2
+	Setting_INT16  = 3
+	Setting_UINT32 = 4
+	Setting_FLOAT  = 5
+	Setting_STRING = 6
+)
+
+// Setting sections, SettingInfo.Section
+const (
+	Setting_MASTER       = 0x00
+	Setting_PROFILE      = 0x08
+	Setting_CONTROL_RATE = 0x10
+	Setting_BATTERY      = 0x18
+	Setting_MIXER        = 0x20
+	Setting_EZ_TUNE      = 0x28
+)
+
+// Profile kinds. A setting of a kind other than Profile_NONE has a value per
+// profile; ProfileCmd is the CLI command that selects a profile of the kind.
+const (
+	Profile_NONE = iota
+	Profile_CONTROL
+	Profile_BATTERY
+	Profile_MIXER
+)
+
+var ProfileCmd = []string{"", "profile", "battery_profile", "mixer_profile"}
+
+var profile_select = []uint16{0, Msp_SELECT_SETTING, Msp_SELECT_BATTERY, Msp_SELECT_MIXER}
+
+// Setting_MODE_LOOKUP marks a setting whose value indexes SettingInfo.Lookup.
+const Setting_MODE_LOOKUP = 0x40
+
+// SettingInfo is the MSP2_COMMON_SETTING_INFO reply: a setting's definition
+// and current value (for the active profile, if the setting has profiles).
+type SettingInfo struct {
+	Name     string
+	PGN      uint16
+	Type     uint8
+	Section  uint8
+	Mode     uint8
+	Min      int32
+	Max      uint32
+	Index    uint16
+	Profile  uint8
+	Profiles uint8
+	Lookup   []string
+	Value    []byte
+}
+
+func cstring(b []byte) (string, []byte, error) {
+	n := bytes.IndexByte(b, 0)
+	if n < 0 {
+		return "", nil, fmt.Errorf("%w: unterminated string", ErrShortPayload)
+	}
+	return string(b[:n]), b[n+1:], nil
+}
+
+func (m *SettingInfo) Unmarshal(b []byte) error {
+	var err error
+	if m.Name, b, err = cstring(b); err != nil {
+		return err
+	}
+	if err = need(b, 17); err != nil {
+		return err
+	}
+	m.PGN = binary.LittleEndian.Uint16(b[0:2])
+	m.Type = b[2]
+	m.Section = b[3]
+	m.Mode = b[4]
+	m.Min = int32(binary.LittleEndian.Uint32(b[5:9]))
+	m.Max = binary.LittleEndian.Uint32(b[9:13])
+	m.Index = binary.LittleEndian.Uint16(b[13:15])
+	m.Profile = b[15]
+	m.Profiles = b[16]
+	b = b[17:]
+	m.Lookup = nil
+	if m.Mode == Setting_MODE_LOOKUP {
+		for j := int64(m.Min); j <= int64(m.Max); j++ {
+			var s string
+			if s, b, err = cstring(b); err != nil {
+				return err
+			}
+			m.Lookup = append(m.Lookup, s)
+		}
+	}
+	m.Value = append([]byte(nil), b...)
+	if m.Type == Setting_STRING {
+		if n := bytes.IndexByte(m.Value, 0); n >= 0 {
+			m.Value = m.Value[:n]
+		}
+	} else if sz := m.size(); sz > 0 {
+		if err = need(m.Value, sz); err != nil {
+			return err
+		}
+		m.Value = m.Value[:sz]
+	}
+	return nil
+}
+
+// ProfileKind is the kind of profile the setting belongs to; Profile is then
+// the index of the active profile of that kind and Profiles their number.
+func (m *SettingInfo) ProfileKind() int {
+	if m.Profiles == 0 {
+		return Profile_NONE
+	}
+	switch m.Section {
+	case Setting_MASTER:
+		return Profile_NONE
+	case Setting_BATTERY:
+		return Profile_BATTERY
+	case Setting_MIXER:
+		return Profile_MIXER
+	}
+	return Profile_CONTROL
+}
+
+// size is the value size of a numeric type, 0 for a string.
+func (m *SettingInfo) size() int {
+	switch m.Type {
+	case Setting_UINT8, Setting_INT8:
+		return 1
+	case Setting_UINT16, Setting_INT16:
+		return 2
+	case Setting_UINT32, Setting_FLOAT:
+		return 4
+	}
+	return 0
+}
+
+// Format renders the value v of the setting as the CLI would.
+func (m *SettingInfo) Format(v []byte) (string, error) {
+	if m.Type == Setting_STRING {
+		return strings.TrimRight(string(v), "\x00"), nil
+	}
+	if m.Type > Setting_STRING {
+		return "", fmt.Errorf("msp: %s: unknown setting type %d", m.Name, m.Type)
+	}
+	if err := need(v, m.size()); err != nil {
+		return "", err
+	}
+	var n int64
+	switch m.Type {
+	case Setting_UINT8:
+		n = int64(v[0])
+	case Setting_INT8:
+		n = int64(int8(v[0]))
+	case Setting_UINT16:
+		n = int64(binary.LittleEndian.Uint16(v))
+	case Setting_INT16:
+		n = int64(int16(binary.LittleEndian.Uint16(v)))
+	case Setting_UINT32:
+		n = int64(binary.LittleEndian.Uint32(v))
+	case Setting_FLOAT:
+		f := math.Float32frombits(binary.LittleEndian.Uint32(v))
+		return strconv.FormatFloat(float64(f), 'f', -1, 32), nil
+	}
+	if m.Mode == Setting_MODE_LOOKUP {
+		if j := n - int64(m.Min); j >= 0 && j < int64(len(m.Lookup)) {
+			return m.Lookup[j], nil
+		}
+	}
+	return strconv.FormatInt(n, 10), nil
+}
+
+// ValueString is the current value, formatted.
+func (m *SettingInfo) ValueString() string {
+	s, err := m.Format(m.Value)
+	if err != nil {
+		return "?"
+	}
+	return s
+}
+
+// Range describes the values the setting accepts.
+func (m *SettingInfo) Range() string {
+	switch {
+	case m.Mode == Setting_MODE_LOOKUP:
+		return strings.Join(m.Lookup, ", ")
+	case m.Type == Setting_STRING:
+		return fmt.Sprintf("string, max %d", m.Max)
+	}
+	return fmt.Sprintf("%d .. %d", m.Min, m.Max)
+}
+
+// Parse encodes the text s as a value of the setting, checking it is within
+// the setting's range.
+func (m *SettingInfo) Parse(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if m.Type == Setting_STRING {
+		if uint32(len(s)) > m.Max {
+			return nil, fmt.Errorf("%s: longer than %d", m.Name, m.Max)
+		}
+		return []byte(s), nil
+	}
+	pl := NewPayload()
+	if m.Type == Setting_FLOAT {
+		f, err := strconv.ParseFloat(s, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid value %q", m.Name, s)
+		}
+		if f < float64(m.Min) || f > float64(m.Max) {
+			return nil, fmt.Errorf("%s: %s outside %s", m.Name, s, m.Range())
+		}
+		return pl.U32(math.Float32bits(float32(f))).Bytes(), nil
+	}
+
+	var n int64
+	var err error
+	if m.Mode == Setting_MODE_LOOKUP {
+		n = -1
+		for j, l := range m.Lookup {
+			if strings.EqualFold(l, s) {
+				n = int64(m.Min) + int64(j)
+			}
+		}
+		if n < 0 {
+			return nil, fmt.Errorf("%s: %q is not one of %s", m.Name, s, m.Range())
+		}
+	} else if n, err = strconv.ParseInt(s, 10, 64); err != nil {
+		return nil, fmt.Errorf("%s: invalid value %q", m.Name, s)
+	} else if n < int64(m.Min) || n > int64(m.Max) {
+		return nil, fmt.Errorf("%s: %s outside %s", m.Name, s, m.Range())
+	}
+	switch m.size() {
+	case 1:
+		pl.U8(uint8(n))
+	case 2:
+		pl.U16(uint16(n))
+	case 4:
+		pl.U32(uint32(n))
+	default:
+		return nil, fmt.Errorf("msp: %s: unknown setting type %d", m.Name, m.Type)
+	}
+	return pl.Bytes(), nil
+}
+
+// PGRange is an entry of MSP2_COMMON_PG_LIST: the settings of a parameter
+// group have indices Start to End inclusive.
+type PGRange struct {
+	PGN   uint16
+	Start uint16
+	End   uint16
+}
+
+func setting_ref(name string) *Payload {
+	return NewPayload().CString(name)
+}
+
+func setting_index(idx uint16) *Payload {
+	return NewPayload().U8(0).U16(idx)
+}
+
+func (p *Client) setting_info(ctx context.Context, ref *Payload) (SettingInfo, error) {
+	var m SettingInfo
+	f, err := p.Request(ctx, Msp_COMMON_SETTING_INFO, ref.Bytes())
+	if err != nil {
+		return m, err
+	}
+	err = m.Unmarshal(f.Data)
+	return m, err
+}
+
+// SettingIndex fetches the setting with the given SettingInfo.Index.
+func (p *Client) SettingIndex(ctx context.Context, idx uint16) (SettingInfo, error) {
+	m, err := p.setting_info(ctx, setting_index(idx))
+	if err != nil {
+		err = fmt.Errorf("setting %d: %w", idx, err)
+	}
+	return m, err
+}
+
+// SettingInfo fetches the definition and value of the named setting.
+func (p *Client) SettingInfo(ctx context.Context, name string) (SettingInfo, error) {
+	m, err := p.setting_info(ctx, setting_ref(name))
+	if err != nil {
+		err = fmt.Errorf("%s: %w", name, err)
+	}
+	return m, err
+}
+
+// SetSetting sets the named setting to the encoded value v (see
+// SettingInfo.Parse). The change is not persistent until Save.
+func (p *Client) SetSetting(ctx context.Context, name string, v []byte) error {
+	_, err := p.Request(ctx, Msp_COMMON_SET_SETTING, setting_ref(name).Data(v).Bytes())
+	if err != nil {
+		err = fmt.Errorf("%s: %w", name, err)
+	}
+	return err
+}
+
+// PGList returns the parameter groups and their setting indices.
+func (p *Client) PGList(ctx context.Context) ([]PGRange, error) {
+	f, err := p.Request(ctx, Msp_COMMON_PG_LIST, nil)
+	if err != nil {
+		return nil, err
+	}
+	var pl []PGRange
+	for b := f.Data; len(b) >= 6; b = b[6:] {
+		pl = append(pl, PGRange{PGN: binary.LittleEndian.Uint16(b[0:2]),
+			Start: binary.LittleEndian.Uint16(b[2:4]), End: binary.LittleEndian.Uint16(b[4:6])})
+	}
+	return pl, nil
+}
+
+// Settings fetches every setting the FC has, parameter group by group. If fn
+// is not nil it is called after each, e.g. for progress.
+func (p *Client) Settings(ctx context.Context, fn func(SettingInfo)) ([]SettingInfo, error) {
+	pgs, err := p.PGList(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var sl []SettingInfo
+	for _, pg := range pgs {
+		for j := int(pg.Start); j <= int(pg.End); j++ {
+			m, err := p.SettingIndex(ctx, uint16(j))
+			if err != nil {
+				return sl, err
+			}
+			sl = append(sl, m)
+			if fn != nil {
+				fn(m)
+			}
+		}
+	}
+	return sl, nil
+}
+
+// ErrProfileNotSelected is returned when the FC acknowledges a profile
+// selection but has not made the change, as INAV does while armed.
+var ErrProfileNotSelected = errors.New("msp: FC did not select the profile")
+
+// SelectProfile makes profile n (from 0) of the kind active. The FC saves its
+// settings to EEPROM when it changes profile, including any not yet saved.
+// The FC may acknowledge the request without acting on it; check the Profile
+// of a setting of the kind fetched afterwards.
+func (p *Client) SelectProfile(ctx context.Context, kind int, n uint8) error {
+	if kind <= Profile_NONE || kind >= len(profile_select) {
+		return fmt.Errorf("msp: invalid profile kind %d", kind)
+	}
+	_, err := p.Request(ctx, profile_select[kind], []byte{n})
+	if err != nil {
+		err = fmt.Errorf("%s %d: %w", ProfileCmd[kind], n+1, err)
+	}
+	return err
+}
+
+// AllSettings is Settings followed by the settings of every profile: those
+// not in a profile first, then for each kind of profile the settings of each
+// profile in turn. The active profiles are selected again before it returns.
+//
+// As it selects each profile in turn, AllSettings makes the FC save its
+// settings to EEPROM, including any changes not yet saved. It returns
+// ErrProfileNotSelected if a selection does not take effect.
+func (p *Client) AllSettings(ctx context.Context, fn func(SettingInfo)) (sl []SettingInfo, err error) {
+	all, err := p.Settings(ctx, fn)
+	if err != nil {
+		return all, err
+	}
+	byk := make([][]SettingInfo, len(ProfileCmd))
+	for _, m := range all {
+		k := m.ProfileKind()
+		if k == Profile_NONE {
+			sl = append(sl, m)
+		} else {
+			byk[k] = append(byk[k], m)
+		}
+	}
+	for k, ks := range byk {
+		if len(ks) == 0 {
+			continue
+		}
+		active := ks[0].Profile
+		for n := uint8(0); n < ks[0].Profiles; n++ {
+			if n == active {
+				sl = append(sl, ks...)
+				continue
+			}
+			if err = p.SelectProfile(ctx, k, n); err != nil {
+				break
+			}
+			for _, m := range ks {
+				if m, err = p.SettingIndex(ctx, m.Index); err != nil {
+					break
+				}
+				if m.Profile != n {
+					err = fmt.Errorf("%w: %s %d", ErrProfileNotSelected, ProfileCmd[k], n+1)
+					break
+				}
+				sl = append(sl, m)
+				if fn != nil {
+					fn(m)
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+		if ks[0].Profiles > 1 {
+			if serr := p.SelectProfile(ctx, k, active); err == nil {
+				err = serr
+			}
+		}
+		if err != nil {
+			return sl, err
+		}
+	}
+	return sl, nil
+}
+
+// Save writes the FC's settings to EEPROM.
+func (p *Client) Save(ctx context.Context) error {
+	_, err := p.Request(ctx, Msp_EEPROM_WRITE, nil)
+	return err
+}
@@ -0,0 +1,78 @@
+package msp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Listeners are kept open across connections so that a new Client for the
+// same address accepts the next peer after the previous one disconnects.
+// ReleaseListener closes one when it is no longer wanted.
+var (
+	lmu       sync.Mutex
+	listeners = make(map[string]*net.TCPListener)
+)
+
+func open_tcp(ctx context.Context, dd DevDescription) (Transport, error) {
+	addr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("%s:%d", dd.Name, dd.Param))
+	if err != nil {
+		return nil, err
+	}
+	if !dd.Listen {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", addr.String())
+	}
+	lmu.Lock()
+	l, ok := listeners[addr.String()]
+	if !ok {
+		l, err = net.ListenTCP("tcp", addr)
+		if err == nil {
+			listeners[addr.String()] = l
+		}
+	}
+	lmu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// A past deadline unblocks the Accept when ctx is cancelled
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			l.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+	conn, err := l.AcceptTCP()
+	close(done)
+	l.SetDeadline(time.Time{})
+	if ctx.Err() != nil {
+		if conn != nil {
+			conn.Close()
+		}
+		return nil, ctx.Err()
+	}
+	return conn, err
+}
+
+// ReleaseListener closes the listener kept for a "tcp://[host]:port?listen"
+// device; a Client waiting on it for a connection fails.
+func ReleaseListener(dname string) error {
+	dd := ParseDevice(dname)
+	addr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("%s:%d", dd.Name, dd.Param))
+	if err != nil {
+		return err
+	}
+	lmu.Lock()
+	l, ok := listeners[addr.String()]
+	delete(listeners, addr.String())
+	lmu.Unlock()
+	if !ok {
+		return nil
+	}
+	return l.Close()
+}
@@ -0,0 +1,76 @@
+package msp
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// udpLink is a bound UDP socket talking to a single peer. If no peer was
+// given it is learned from the source of received datagrams, so nothing is
+// sent until the first one arrives.
+type udpLink struct {
+	conn  *net.UDPConn
+	mu    sync.Mutex
+	peer  *net.UDPAddr
+	learn bool
+}
+
+func open_udp(dd DevDescription) (Transport, error) {
+	var laddr *net.UDPAddr
+	var raddr *net.UDPAddr
+	var err error
+	lhost, lport := dd.Name, dd.Param
+	rhost, rport := dd.Name1, dd.Param1
+	if dd.Name1 == "" && dd.Name != "" {
+		// udp://host:port, no local binding
+		raddr, err = net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", dd.Name, dd.Param))
+		if err != nil {
+			return nil, err
+		}
+		return net.DialUDP("udp", nil, raddr)
+	}
+	if lport < 0 {
+		lport = 0
+	}
+	laddr, err = net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", lhost, lport))
+	if err != nil {
+		return nil, err
+	}
+	if rhost != "" && rport > 0 {
+		raddr, err = net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", rhost, rport))
+		if err != nil {
+			return nil, err
+		}
+	}
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	return &udpLink{conn: conn, peer: raddr, learn: raddr == nil}, nil
+}
+
+func (u *udpLink) Read(buf []byte) (int, error) {
+	n, addr, err := u.conn.ReadFromUDP(buf)
+	if err == nil && u.learn {
+		u.mu.Lock()
+		u.peer = addr
+		u.mu.Unlock()
+	}
+	return n, err
+}
+
+// Write drops data until a peer is known.
+func (u *udpLink) Write(buf []byte) (int, error) {
+	u.mu.Lock()
+	peer := u.peer
+	u.mu.Unlock()
+	if peer == nil {
+		return len(buf), nil
+	}
+	return u.conn.WriteToUDP(buf, peer)
+}
+
+func (u *udpLink) Close() error {
+	return u.conn.Close()
+}
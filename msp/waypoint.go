@@ -0,0 +1,214 @@
+package msp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Waypoint actions
+const (
+	WP_WAYPOINT      = 1
+	WP_POSHOLD_UNLIM = 2
+	WP_POSHOLD_TIME  = 3
+	WP_RTH           = 4
+	WP_SET_POI       = 5
+	WP_JUMP          = 6
+	WP_SET_HEAD      = 7
+	WP_LAND          = 8
+)
+
+// WP_FLAG_LAST marks the final waypoint of a mission.
+const WP_FLAG_LAST = 0xa5
+
+var wpactions = map[uint8]string{
+	WP_WAYPOINT:      "WAYPOINT",
+	WP_POSHOLD_UNLIM: "POSHOLD_UNLIM",
+	WP_POSHOLD_TIME:  "POSHOLD_TIME",
+	WP_RTH:           "RTH",
+	WP_SET_POI:       "SET_POI",
+	WP_JUMP:          "JUMP",
+	WP_SET_HEAD:      "SET_HEAD",
+	WP_LAND:          "LAND",
+}
+
+// WPActionName returns the INAV name of a waypoint action.
+func WPActionName(a uint8) string {
+	if s, ok := wpactions[a]; ok {
+		return s
+	}
+	return fmt.Sprintf("ACTION_%d", a)
+}
+
+// WPAction returns the action with the INAV name s.
+func WPAction(s string) (uint8, bool) {
+	for a, n := range wpactions {
+		if n == s {
+			return a, true
+		}
+	}
+	return 0, false
+}
+
+// Waypoint is MSP_WP / MSP_SET_WP; Lat and Lon are degrees * 1e7, Alt cm.
+type Waypoint struct {
+	No     uint8
+	Action uint8
+	Lat    int32
+	Lon    int32
+	Alt    int32
+	P1     int16
+	P2     int16
+	P3     int16
+	Flag   uint8
+}
+
+func (m *Waypoint) Unmarshal(b []byte) error {
+	if err := need(b, 21); err != nil {
+		return err
+	}
+	m.No = b[0]
+	m.Action = b[1]
+	m.Lat = int32(binary.LittleEndian.Uint32(b[2:6]))
+	m.Lon = int32(binary.LittleEndian.Uint32(b[6:10]))
+	m.Alt = int32(binary.LittleEndian.Uint32(b[10:14]))
+	m.P1 = int16(binary.LittleEndian.Uint16(b[14:16]))
+	m.P2 = int16(binary.LittleEndian.Uint16(b[16:18]))
+	m.P3 = int16(binary.LittleEndian.Uint16(b[18:20]))
+	m.Flag = b[20]
+	return nil
+}
+
+// Marshal encodes the waypoint as the MSP_SET_WP payload.
+func (m *Waypoint) Marshal() []byte {
+	return NewPayload().U8(m.No).U8(m.Action).I32(m.Lat).I32(m.Lon).I32(m.Alt).
+		I16(m.P1).I16(m.P2).I16(m.P3).U8(m.Flag).Bytes()
+}
+
+// Geo reports whether the waypoint has a position.
+func (m *Waypoint) Geo() bool {
+	switch m.Action {
+	case WP_RTH, WP_JUMP, WP_SET_HEAD:
+		return false
+	}
+	return true
+}
+
+// DownloadMission reads the FC's mission with MSP_WP, starting at waypoint 1
+// and stopping after the one flagged WP_FLAG_LAST.
+func (p *Client) DownloadMission(ctx context.Context) ([]Waypoint, error) {
+	f, err := p.Request(ctx, Msp_WP_GETINFO, nil)
+	if err != nil {
+		return nil, err
+	}
+	var info WPInfo
+	if err = info.Unmarshal(f.Data); err != nil {
+		return nil, err
+	}
+	var wps []Waypoint
+	for n := 1; n <= int(info.Count); n++ {
+		f, err = p.Request(ctx, Msp_WP, []byte{uint8(n)})
+		if err != nil {
+			return wps, err
+		}
+		var wp Waypoint
+		if err = wp.Unmarshal(f.Data); err != nil {
+			return wps, fmt.Errorf("waypoint %d: %w", n, err)
+		}
+		wps = append(wps, wp)
+		if wp.Flag == WP_FLAG_LAST {
+			break
+		}
+	}
+	return wps, nil
+}
+
+// MissionMismatch is returned by VerifyMission for the first waypoint that
+// differs from what was uploaded.
+type MissionMismatch struct {
+	Want Waypoint
+	Got  Waypoint
+}
+
+func (e *MissionMismatch) Error() string {
+	w, g := e.Want, e.Got
+	fields := []struct {
+		name      string
+		want, got int64
+	}{
+		{"number", int64(w.No), int64(g.No)},
+		{"action", int64(w.Action), int64(g.Action)},
+		{"lat", int64(w.Lat), int64(g.Lat)},
+		{"lon", int64(w.Lon), int64(g.Lon)},
+		{"alt", int64(w.Alt), int64(g.Alt)},
+		{"p1", int64(w.P1), int64(g.P1)},
+		{"p2", int64(w.P2), int64(g.P2)},
+		{"p3", int64(w.P3), int64(g.P3)},
+		{"flag", int64(w.Flag), int64(g.Flag)},
+	}
+	for _, f := range fields {
+		if f.want != f.got {
+			return fmt.Sprintf("msp: waypoint %d: %s sent %d, FC has %d", w.No, f.name, f.want, f.got)
+		}
+	}
+	return fmt.Sprintf("msp: waypoint %d differs", w.No)
+}
+
+var ErrMissionInvalid = errors.New("msp: FC reports mission invalid")
+
+// UploadMission sends wps to the FC with MSP_SET_WP. The waypoints must be
+// numbered from 1 with the last flagged WP_FLAG_LAST.
+func (p *Client) UploadMission(ctx context.Context, wps []Waypoint) error {
+	f, err := p.Request(ctx, Msp_WP_GETINFO, nil)
+	if err != nil {
+		return err
+	}
+	var info WPInfo
+	if err = info.Unmarshal(f.Data); err != nil {
+		return err
+	}
+	if len(wps) > int(info.MaxWaypoints) {
+		return fmt.Errorf("msp: mission has %d waypoints, FC allows %d", len(wps), info.MaxWaypoints)
+	}
+	for _, wp := range wps {
+		if _, err = p.Request(ctx, Msp_SET_WP, wp.Marshal()); err != nil {
+			return fmt.Errorf("waypoint %d: %w", wp.No, err)
+		}
+	}
+	return nil
+}
+
+// VerifyMission reads back each waypoint of wps, returning a *MissionMismatch
+// for the first that differs, then checks that MSP_WP_GETINFO reports a valid
+// mission of the same length.
+func (p *Client) VerifyMission(ctx context.Context, wps []Waypoint) error {
+	for _, want := range wps {
+		f, err := p.Request(ctx, Msp_WP, []byte{want.No})
+		if err != nil {
+			return fmt.Errorf("waypoint %d: %w", want.No, err)
+		}
+		var got Waypoint
+		if err = got.Unmarshal(f.Data); err != nil {
+			return fmt.Errorf("waypoint %d: %w", want.No, err)
+		}
+		if got != want {
+			return &MissionMismatch{Want: want, Got: got}
+		}
+	}
+	f, err := p.Request(ctx, Msp_WP_GETINFO, nil)
+	if err != nil {
+		return err
+	}
+	var info WPInfo
+	if err = info.Unmarshal(f.Data); err != nil {
+		return err
+	}
+	if !info.Valid {
+		return ErrMissionInvalid
+	}
+	if int(info.Count) != len(wps) {
+		return fmt.Errorf("%w: %d waypoints, expected %d", ErrMissionInvalid, info.Count, len(wps))
+	}
+	return nil
+}
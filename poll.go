@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-ini/ini"
+	"github.com/stronnag/msp-go/msp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// Target poll rates (Hz) for the telemetry lines, by name as used in -rates
+// and the [rates] section of the -config file. 0 disables a line.
+var poll_rates = map[string]float64{
+	"attitude": 10,
+	"gps":      5,
+	"status":   1,
+	"analog":   1,
+	"uptime":   1,
+}
+
+func set_rate(key, val string) error {
+	key = strings.ToLower(strings.TrimSpace(key))
+	if _, ok := poll_rates[key]; !ok {
+		return fmt.Errorf("unknown rate %q", key)
+	}
+	r, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+	if err != nil || r < 0 {
+		return fmt.Errorf("invalid rate %s=%s", key, val)
+	}
+	poll_rates[key] = r
+	return nil
+}
+
+// parse_rates applies a "gps=5,status=1" style list.
+func parse_rates(spec string) error {
+	for _, p := range strings.Split(spec, ",") {
+		if p == "" {
+			continue
+		}
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid rate %q", p)
+		}
+		if err := set_rate(kv[0], kv[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func load_rates(fname string) error {
+	cfg, err := ini.Load(fname)
+	if err != nil {
+		return err
+	}
+	for _, k := range cfg.Section("rates").Keys() {
+		if err := set_rate(k.Name(), k.Value()); err != nil {
+			return fmt.Errorf("%s: %v", fname, err)
+		}
+	}
+	return nil
+}
+
+func cap_rates(max float64) {
+	for k, r := range poll_rates {
+		if r > max {
+			poll_rates[k] = max
+		}
+	}
+}
+
+type poller struct {
+	name string
+	cmd  uint16
+}
+
+// request is a synchronous query whose reply is decoded into m.
+func request(ctx context.Context, s tcell.Screen, sp *msp.Client, cmd uint16, id int, m msp.Unmarshaler) bool {
+	f, err := sp.Request(ctx, cmd, nil)
+	if err != nil {
+		return false
+	}
+	return decode_value(s, id, f, m)
+}
+
+// identify fills in the static lines and returns the FC variant.
+func identify(ctx context.Context, s tcell.Screen, sp *msp.Client, style tcell.Style) string {
+	var ident msp.Ident
+	if request(ctx, s, sp, msp.Msp_IDENT, IY_MW, &ident) {
+		txt := fmt.Sprintf("MW Compat: %d, (msp protocol v%d)", ident.Version, sp.Version())
+		set_value(s, IY_MW, txt, style)
+	}
+	var name msp.Name
+	if request(ctx, s, sp, msp.Msp_NAME, IY_NAME, &name) && name.Name != "" {
+		set_value(s, IY_NAME, name.Name, style)
+	}
+	var apiv msp.APIVersion
+	if request(ctx, s, sp, msp.Msp_API_VERSION, IY_APIV, &apiv) {
+		txt := fmt.Sprintf("%d.%d (%d)", apiv.Major, apiv.Minor, sp.Version())
+		set_value(s, IY_APIV, txt, style)
+	}
+	var fcv msp.FCVariant
+	if request(ctx, s, sp, msp.Msp_FC_VARIANT, IY_FC, &fcv) {
+		set_value(s, IY_FC, fcv.Variant, style)
+	}
+	var vers msp.FCVersion
+	if request(ctx, s, sp, msp.Msp_FC_VERSION, IY_FCVERS, &vers) {
+		txt := fmt.Sprintf("%d.%d.%d", vers.Major, vers.Minor, vers.Patch)
+		set_value(s, IY_FCVERS, txt, style)
+	}
+	var build msp.BuildInfo
+	if request(ctx, s, sp, msp.Msp_BUILD_INFO, IY_BUILD, &build) {
+		txt := fmt.Sprintf("%s %s (%s)", build.Date, build.Time, build.Revision)
+		set_value(s, IY_BUILD, txt, style)
+	}
+	var board msp.BoardInfo
+	if request(ctx, s, sp, msp.Msp_BOARD_INFO, IY_BOARD, &board) {
+		set_value(s, IY_BOARD, board.Board(), style)
+	}
+	var wpi msp.WPInfo
+	if request(ctx, s, sp, msp.Msp_WP_GETINFO, IY_WPINFO, &wpi) {
+		txt := fmt.Sprintf("%d of %d, valid %v", wpi.Count, wpi.MaxWaypoints, wpi.Valid)
+		set_value(s, IY_WPINFO, txt, style)
+	}
+	s.Show()
+	return fcv.Variant
+}
+
+// schedule adds the telemetry lines to sc at the configured rates, using the
+// INAV MSPv2 messages where the FC has them.
+func schedule(s tcell.Screen, sp *msp.Client, sc *msp.Scheduler, inav bool, style tcell.Style) []poller {
+	var pl []poller
+	add := func(name string, cmd uint16, fn func(msp.Frame)) {
+		if sc.Add(cmd, poll_rates[name], func(f msp.Frame) {
+			fn(f)
+			s.Show()
+		}) != nil {
+			pl = append(pl, poller{name, cmd})
+		}
+	}
+
+	if inav && sp.Version() == 2 {
+		add("uptime", msp.Msp_MISC2, func(f msp.Frame) {
+			var m msp.Misc2
+			if decode_value(s, IY_UPTIME, f, &m) {
+				set_value(s, IY_UPTIME, fmt.Sprintf("%ds", m.Uptime), style)
+			}
+		})
+		add("analog", msp.Msp_ANALOG2, func(f msp.Frame) {
+			var m msp.Analog2
+			if decode_value(s, IY_ANALOG, f, &m) {
+				txt := fmt.Sprintf("volts: %.1f, amps: %.2f", m.Volts, m.Amps)
+				set_value(s, IY_ANALOG, txt, style)
+			}
+		})
+		add("status", msp.Msp_INAV_STATUS, func(f msp.Frame) {
+			var m msp.InavStatus
+			if decode_value(s, IY_ARM, f, &m) {
+				set_value(s, IY_ARM, arm_status(m.ArmingFlags), style)
+			}
+		})
+	} else {
+		add("analog", msp.Msp_ANALOG, func(f msp.Frame) {
+			var m msp.Analog
+			if decode_value(s, IY_ANALOG, f, &m) {
+				txt := fmt.Sprintf("volts: %.1f, amps: %.2f", m.Volts, m.Amps)
+				set_value(s, IY_ANALOG, txt, style)
+			}
+		})
+		add("status", msp.Msp_STATUS_EX, func(f msp.Frame) {
+			var m msp.StatusEx
+			if decode_value(s, IY_ARM, f, &m) {
+				set_value(s, IY_ARM, arm_status(uint32(m.ArmingFlags)), style)
+			}
+		})
+	}
+	add("gps", msp.Msp_RAW_GPS, func(f msp.Frame) {
+		var m msp.RawGPS
+		if decode_value(s, IY_GPS, f, &m) {
+			txt := fmt.Sprintf("fix %d, sats %d,  %.6f° %.6f° %dm, %.0fm/s %.0f°", m.Fix, m.NumSat, m.Lat, m.Lon, m.Alt, m.Speed, m.Course)
+			if m.HasHDOP {
+				txt = txt + fmt.Sprintf(" hdop %.2f", m.HDOP)
+			}
+			set_value(s, IY_GPS, txt, style)
+		}
+	})
+	add("attitude", msp.Msp_ATTITUDE, func(f msp.Frame) {
+		var m msp.Attitude
+		if decode_value(s, IY_ATT, f, &m) {
+			txt := fmt.Sprintf("roll %.1f°, pitch %.1f°, heading %d°", m.Roll, m.Pitch, m.Heading)
+			set_value(s, IY_ATT, txt, style)
+		}
+	})
+	return pl
+}
+
+// achieved formats the rate each line is actually being polled at.
+func achieved(sc *msp.Scheduler, pl []poller) string {
+	ach := sc.Achieved()
+	sort.Slice(pl, func(i, j int) bool { return pl[i].name < pl[j].name })
+	var sa []string
+	for _, p := range pl {
+		sa = append(sa, fmt.Sprintf("%s %.1f/%.0f", p.name, ach[p.cmd], poll_rates[p.name]))
+	}
+	return strings.Join(sa, ", ")
+}
+
+// stalled lists the commands that have stopped answering.
+func stalled(sc *msp.Scheduler) string {
+	st := sc.Stalled()
+	cmds := make([]int, 0, len(st))
+	for c := range st {
+		cmds = append(cmds, int(c))
+	}
+	sort.Ints(cmds)
+	var sa []string
+	for _, c := range cmds {
+		sa = append(sa, fmt.Sprintf("%s (%d misses)", msp.CmdName(uint16(c)), st[uint16(c)]))
+	}
+	return strings.Join(sa, ", ")
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"github.com/stronnag/msp-go/msp"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// profiles is the profile of each kind (from 1, 0 if none) selected by the
+// preceding "profile N" etc. lines of a settings file.
+type profiles [msp.Profile_MIXER + 1]int
+
+type setting struct {
+	name  string
+	value string
+	prof  profiles
+}
+
+// profile_line parses a "profile N", "battery_profile N" or "mixer_profile N"
+// line, returning its kind and N.
+func profile_line(line string) (int, int, bool) {
+	parts := strings.Fields(line)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	for k, c := range msp.ProfileCmd {
+		if c != "" && c == parts[0] {
+			n, err := strconv.Atoi(parts[1])
+			if err != nil || n < 1 {
+				return k, 0, true
+			}
+			return k, n, true
+		}
+	}
+	return 0, 0, false
+}
+
+// read_settings reads CLI style "set name = value" lines, each applying to
+// the profiles selected by the "profile N" etc. lines before it; anything
+// else (comments, other CLI commands) is skipped and counted.
+func read_settings(r io.Reader) ([]setting, int, error) {
+	var sl []setting
+	var prof profiles
+	skipped := 0
+	lno := 0
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		lno++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if k, n, ok := profile_line(line); ok {
+			if n == 0 {
+				return sl, skipped, fmt.Errorf("line %d: invalid %s", lno, msp.ProfileCmd[k])
+			}
+			prof[k] = n
+			continue
+		}
+		if !strings.HasPrefix(line, "set ") {
+			skipped++
+			continue
+		}
+		kv := strings.SplitN(strings.TrimPrefix(line, "set "), "=", 2)
+		if len(kv) != 2 {
+			skipped++
+			continue
+		}
+		sl = append(sl, setting{strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]), prof})
+	}
+	return sl, skipped, sc.Err()
+}
+
+func has_profiles(sl []setting) bool {
+	for _, s := range sl {
+		if s.prof != (profiles{}) {
+			return true
+		}
+	}
+	return false
+}
+
+func load_settings(fname string) ([]setting, error) {
+	fh, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	sl, skipped, err := read_settings(fh)
+	if skipped > 0 {
+		log.Printf("settings: %s: skipped %d lines that are not 'set'", fname, skipped)
+	}
+	return sl, err
+}
+
+// fc_ident describes the FC for file headers.
+func fc_ident(ctx context.Context, sp *msp.Client) string {
+	var sa []string
+	var fcv msp.FCVariant
+	if f, err := sp.Request(ctx, msp.Msp_FC_VARIANT, nil); err == nil && fcv.Unmarshal(f.Data) == nil {
+		sa = append(sa, fcv.Variant)
+	}
+	var vers msp.FCVersion
+	if f, err := sp.Request(ctx, msp.Msp_FC_VERSION, nil); err == nil && vers.Unmarshal(f.Data) == nil {
+		sa = append(sa, fmt.Sprintf("%d.%d.%d", vers.Major, vers.Minor, vers.Patch))
+	}
+	var board msp.BoardInfo
+	if f, err := sp.Request(ctx, msp.Msp_BOARD_INFO, nil); err == nil && board.Unmarshal(f.Data) == nil {
+		sa = append(sa, board.Board())
+	}
+	var name msp.Name
+	if f, err := sp.Request(ctx, msp.Msp_NAME, nil); err == nil && name.Unmarshal(f.Data) == nil && name.Name != "" {
+		sa = append(sa, name.Name)
+	}
+	return strings.Join(sa, " ")
+}
+
+// setting_key names a setting of profile n (from 1) of its kind, e.g.
+// "roll_rate (profile 2)".
+func setting_key(name string, kind int, n int) string {
+	if kind == msp.Profile_NONE {
+		return name
+	}
+	return fmt.Sprintf("%s (%s %d)", name, msp.ProfileCmd[kind], n)
+}
+
+func info_key(m *msp.SettingInfo) string {
+	return setting_key(m.Name, m.ProfileKind(), int(m.Profile)+1)
+}
+
+// fetch_settings reads every setting, of every profile if all is set,
+// showing progress on stderr.
+func fetch_settings(ctx context.Context, sp *msp.Client, all bool) ([]msp.SettingInfo, error) {
+	n := 0
+	progress := func(msp.SettingInfo) {
+		n++
+		if n%50 == 0 {
+			fmt.Fprintf(os.Stderr, "\r%d settings", n)
+		}
+	}
+	var sl []msp.SettingInfo
+	var err error
+	if all {
+		log.Printf("settings: selecting each profile in turn, the FC will save its settings to EEPROM")
+		sl, err = sp.AllSettings(ctx, progress)
+	} else {
+		sl, err = sp.Settings(ctx, progress)
+	}
+	fmt.Fprintf(os.Stderr, "\r%d settings\n", len(sl))
+	return sl, err
+}
+
+func settings_dump(args []string) {
+	fs := flag.NewFlagSet("settings dump", flag.ExitOnError)
+	ofile := fs.String("o", "", "Output file (default stdout)")
+	active := fs.Bool("active", false, "Dump the active profiles only, without selecting others (which saves to EEPROM)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of mspview settings dump [options] device\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	ctx := context.Background()
+	sp, err := open_fc(ctx, fs.Arg(0))
+	if err != nil {
+		log.Fatalf("settings: %v", err)
+	}
+	defer sp.Close()
+	ident := fc_ident(ctx, sp)
+	sl, err := fetch_settings(ctx, sp, !*active)
+	if err != nil {
+		log.Fatalf("settings: %v", err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *ofile != "" {
+		fh, err := os.Create(*ofile)
+		if err != nil {
+			log.Fatalf("settings: %v", err)
+		}
+		defer fh.Close()
+		w = fh
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# mspview %s settings dump, %s\n", VERSION, time.Now().Format(time.RFC3339))
+	fmt.Fprintf(bw, "# %s\n\n", ident)
+	kind, prof := msp.Profile_NONE, uint8(0)
+	for _, s := range sl {
+		if k := s.ProfileKind(); k != msp.Profile_NONE && (k != kind || s.Profile != prof) {
+			kind, prof = k, s.Profile
+			fmt.Fprintf(bw, "\n%s %d\n", msp.ProfileCmd[kind], prof+1)
+		}
+		fmt.Fprintf(bw, "set %s = %s\n", s.Name, s.ValueString())
+	}
+	if err = bw.Flush(); err != nil {
+		log.Fatalf("settings: %v", err)
+	}
+}
+
+func settings_restore(args []string) {
+	fs := flag.NewFlagSet("settings restore", flag.ExitOnError)
+	dryrun := fs.Bool("n", false, "Show what would change, but change nothing")
+	nosave := fs.Bool("nosave", false, "Do not save to EEPROM (refused for files with profile sections)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of mspview settings restore [options] file device\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	sl, err := load_settings(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("settings: %v", err)
+	}
+	// selecting a profile makes the FC save its settings, so any change
+	// already made would be saved
+	if *nosave && has_profiles(sl) {
+		log.Fatalf("settings: %s has profile sections, which cannot be restored without saving to EEPROM", fs.Arg(0))
+	}
+	ctx := context.Background()
+	sp, err := open_fc(ctx, fs.Arg(1))
+	if err != nil {
+		log.Fatalf("settings: %v", err)
+	}
+	defer sp.Close()
+
+	// the active profile of each kind changed, to be selected again
+	active := make(map[int]uint8)
+	changed, failed, unchecked := 0, 0, 0
+	for _, s := range sl {
+		info, err := sp.SettingInfo(ctx, s.name)
+		if err != nil {
+			log.Printf("settings: %v", err)
+			failed++
+			continue
+		}
+		if k := info.ProfileKind(); k != msp.Profile_NONE && s.prof[k] > 0 && s.prof[k]-1 != int(info.Profile) {
+			if s.prof[k] > int(info.Profiles) {
+				log.Printf("settings: %s: FC has %d %ss", setting_key(s.name, k, s.prof[k]), info.Profiles, msp.ProfileCmd[k])
+				failed++
+				continue
+			}
+			if *dryrun {
+				unchecked++
+				continue
+			}
+			if _, ok := active[k]; !ok {
+				active[k] = info.Profile
+			}
+			if err = sp.SelectProfile(ctx, k, uint8(s.prof[k]-1)); err == nil {
+				info, err = sp.SettingInfo(ctx, s.name)
+			}
+			if err == nil && int(info.Profile) != s.prof[k]-1 {
+				err = fmt.Errorf("%w: %s %d", msp.ErrProfileNotSelected, msp.ProfileCmd[k], s.prof[k])
+			}
+			if err != nil {
+				log.Printf("settings: %v", err)
+				failed++
+				continue
+			}
+		}
+		v, err := info.Parse(s.value)
+		if err != nil {
+			log.Printf("settings: %v", err)
+			failed++
+			continue
+		}
+		if bytes.Equal(v, info.Value) {
+			continue
+		}
+		fmt.Printf("%s: %s -> %s\n", info_key(&info), info.ValueString(), s.value)
+		changed++
+		if !*dryrun {
+			if err = sp.SetSetting(ctx, s.name, v); err != nil {
+				log.Printf("settings: %v", err)
+				failed++
+			}
+		}
+	}
+	for k, n := range active {
+		if err = sp.SelectProfile(ctx, k, n); err != nil {
+			log.Printf("settings: %v", err)
+			failed++
+		}
+	}
+	if unchecked > 0 {
+		log.Printf("settings: %d settings of inactive profiles not checked, as selecting a profile saves to EEPROM", unchecked)
+	}
+	log.Printf("settings: %d read, %d changed, %d failed", len(sl), changed, failed)
+	if changed > 0 && !*dryrun && !*nosave {
+		if err = sp.Save(ctx); err != nil {
+			log.Fatalf("settings: save: %v", err)
+		}
+		log.Printf("settings: saved to EEPROM")
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func run_settings(args []string) {
+	if len(args) > 0 {
+		switch args[0] {
+		case "dump":
+			settings_dump(args[1:])
+			return
+		case "restore":
+			settings_restore(args[1:])
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Usage of mspview settings dump [options] device\n")
+	fmt.Fprintf(os.Stderr, "       mspview settings restore [options] file device\n")
+	os.Exit(1)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/stronnag/msp-go/msp"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type sniffer struct {
+	mu sync.Mutex
+	cw *msp.CaptureWriter
+}
+
+func frame_trace(t time.Time, f msp.Frame) string {
+	vers := 1
+	if f.V2 {
+		vers = 2
+	}
+	crc := "ok"
+	if f.Err == msp.ErrCRC {
+		crc = "BAD"
+	}
+	str := fmt.Sprintf("%s %c v%d %s (%d) len %d crc %s", t.Format("15:04:05.000000"),
+		f.Dirn, vers, msp.CmdName(f.Cmd), f.Cmd, f.Len, crc)
+	if f.Flags != 0 {
+		str += fmt.Sprintf(" flags 0x%x", f.Flags)
+	}
+	if f.Err == nil && f.Dirn == '>' {
+		m, err := msp.Decode(f.Cmd, f.Data)
+		if err != nil {
+			str += fmt.Sprintf(" [%v]", err)
+		} else if m != nil {
+			str += " " + strings.TrimPrefix(fmt.Sprintf("%+v", m), "&")
+		} else if f.Len > 0 {
+			str += fmt.Sprintf(" [% x]", f.Data)
+		}
+	} else if f.Len > 0 {
+		str += fmt.Sprintf(" [% x]", f.Data)
+	}
+	return str
+}
+
+func (s *sniffer) frame(t time.Time, f msp.Frame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Println(frame_trace(t, f))
+	if s.cw != nil {
+		if err := s.cw.WriteFrame(t, f.Raw); err != nil {
+			log.Fatalf("sniff: %v", err)
+		}
+	}
+}
+
+// pipe parses everything read from r, optionally copying it on to w.
+func (s *sniffer) pipe(r io.Reader, w io.Writer) error {
+	parser := msp.Parser{KeepRaw: true}
+	buf := make([]byte, 1024)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			time.Sleep(100 * time.Microsecond)
+			continue
+		}
+		if w != nil {
+			if _, err = w.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+		now := time.Now()
+		for _, c := range buf[:n] {
+			for _, f := range parser.Parse(c) {
+				s.frame(now, f)
+			}
+		}
+	}
+}
+
+func (s *sniffer) replay(fname string) error {
+	fh, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	cr, err := msp.NewCaptureReader(fh)
+	if err != nil {
+		return err
+	}
+	for {
+		t, raw, err := cr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var parser msp.Parser
+		for _, c := range raw {
+			for _, f := range parser.Parse(c) {
+				fmt.Println(frame_trace(t, f))
+			}
+		}
+	}
+}
+
+// proxy accepts a client on listen and relays it to devnam, tracing both
+// directions, until the client goes away; then it waits for the next one.
+func (s *sniffer) proxy(listen string, devnam string) error {
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		up, err := msp.OpenDevice(msp.ParseDevice(devnam))
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		log.Printf("sniff: %s <-> %s", conn.RemoteAddr(), devnam)
+		done := make(chan error, 2)
+		go func() { done <- s.pipe(conn, up) }()
+		go func() { done <- s.pipe(up, conn) }()
+		err = <-done
+		conn.Close()
+		up.Close()
+		<-done
+		log.Printf("sniff: %s closed (%v)", conn.RemoteAddr(), err)
+	}
+}
+
+func run_sniff(args []string) {
+	fs := flag.NewFlagSet("sniff", flag.ExitOnError)
+	wfile := fs.String("w", "", "Save frames to capture file")
+	rfile := fs.String("r", "", "Decode a capture file")
+	listen := fs.String("proxy", "", "Relay TCP clients on this address to the device")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of mspview sniff [options] device [device ...]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	sn := &sniffer{}
+	if *rfile != "" {
+		if err := sn.replay(*rfile); err != nil {
+			log.Fatalf("sniff: %v", err)
+		}
+		return
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	if *wfile != "" {
+		fh, err := os.Create(*wfile)
+		if err != nil {
+			log.Fatalf("sniff: %v", err)
+		}
+		defer fh.Close()
+		if sn.cw, err = msp.NewCaptureWriter(fh); err != nil {
+			log.Fatalf("sniff: %v", err)
+		}
+	}
+
+	if *listen != "" {
+		if err := sn.proxy(*listen, fs.Arg(0)); err != nil {
+			log.Printf("sniff: %v", err)
+		}
+		return
+	}
+
+	// Passive tap, e.g. the TX and RX lines on two USB/UART adaptors
+	done := make(chan error)
+	for _, devnam := range fs.Args() {
+		t, err := msp.OpenDevice(msp.ParseDevice(devnam))
+		if err != nil {
+			log.Fatalf("sniff: %s: %v", devnam, err)
+		}
+		go func(t msp.Transport) {
+			done <- sn.pipe(t, nil)
+		}(t)
+	}
+	err := <-done
+	log.Printf("sniff: %v", err)
+}